@@ -130,6 +130,12 @@ func main() {
 				switch strings.ToLower(c.Type) {
 				case "reboot":
 					go simulateReboot(*id, *verbose)
+				case "factory_reset":
+					// пример payload: {"mode":"hard"}
+					if *verbose {
+						log.Printf("factory_reset (fake): %s", string(c.Payload))
+					}
+					go simulateReboot(*id, *verbose)
 				case "setparam":
 					// пример payload: {"name":"threshold","value":123}
 					if *verbose {
@@ -2,34 +2,31 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"os/signal"
+	"slices"
 	"syscall"
+	"time"
 
 	"sstmk-onvif/internal/adapters/udp"
+	"sstmk-onvif/internal/auth"
 	"sstmk-onvif/internal/tty"
 
 	"sstmk-onvif/internal/bootstrap"
 	"sstmk-onvif/internal/config"
+	"sstmk-onvif/internal/devcmd"
+	"sstmk-onvif/internal/discovery"
 	"sstmk-onvif/internal/events"
+	"sstmk-onvif/internal/httpdev"
 	"sstmk-onvif/internal/hub"
+	"sstmk-onvif/internal/onvif"
 	"sstmk-onvif/internal/registry"
 	"sstmk-onvif/internal/sstmk"
 	"sstmk-onvif/internal/state"
 	"sstmk-onvif/internal/web"
 )
 
-// isBuiltInDevice проверяет, является ли устройство вшитым
-func isBuiltInDevice(uid string) bool {
-	builtInDevices := []string{"gate-001", "gate-002", "gate-003", "gate-004"}
-	for _, builtInUID := range builtInDevices {
-		if uid == builtInUID {
-			return true
-		}
-	}
-	return false
-}
-
 func main() {
 
 	statePath := "./webui/config/state.json"
@@ -62,20 +59,64 @@ func main() {
 			Revision:     d.Revision,
 			Adapter:      d.Adapter,
 			AdapterDS:    d.AdapterDS,
+			Hostname:     d.Hostname,
+			TimeZone:     d.TimeZone,
+			DNS:          d.DNS,
+			NTP:          d.NTP,
+			Scopes:       d.Scopes,
+			EndpointRef:  d.EndpointRef,
 		})
 		// Восстанавливаем enabled из state.json
 		reg.SetEnabled(d.UID, d.Enabled)
-		// Для вшитых устройств всегда online=true
-		if isBuiltInDevice(d.UID) {
+		// Для вшитых устройств всегда online=true; обнаруженные — offline,
+		// пока не ответят (регистрация по UDP, монитор), иначе при старте
+		// ушёл бы Hello для детектора, которого уже нет
+		if registry.IsBuiltIn(d.UID) {
 			reg.SetOnline(d.UID, true)
-		} else {
-			reg.SetOnline(d.UID, d.Online)
+		}
+	}
+
+	// Удалённые устройства — чтобы регистрация по UDP вернула их выключенными
+	reg.SetDeleted(st.Deleted)
+
+	// EndpointReference выдаёт реестр; state.json без него (старый формат) дописываем,
+	// чтобы адрес не зависел от способа вывода
+	for _, d := range st.Devices {
+		if d.EndpointRef == "" {
+			if err := state.SaveRegistry(statePath, reg); err != nil {
+				log.Printf("state save error: %v", err)
+			}
+			break
 		}
 	}
 
 	evbuf := events.NewRing(1024)
 	hb := hub.New()
 
+	// Переходы online/offline — в шину событий (ONVIF tmk:Device/tmk:Status)
+	reg.OnChange(func(c registry.Change) {
+		if c.Removed || c.Prev.Online == c.Cur.Online {
+			return
+		}
+		b, _ := json.Marshal(events.OnlinePayload{Online: c.Cur.Online})
+		evbuf.Push(events.Event{
+			DeviceID: c.Cur.UID,
+			Topic:    events.TopicOnline,
+			Payload:  b,
+			Time:     time.Now(),
+		})
+	})
+
+	// Scope, изменённые через ONVIF (SetScopes/AddScopes/RemoveScopes), — в state.json
+	reg.OnChange(func(c registry.Change) {
+		if c.Added || c.Removed || slices.Equal(c.Prev.Scopes, c.Cur.Scopes) {
+			return
+		}
+		if err := state.SaveRegistry(statePath, reg); err != nil {
+			log.Printf("state save error: %v", err)
+		}
+	})
+
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer cancel()
 
@@ -83,9 +124,76 @@ func main() {
 
 	errCh := make(chan error, 2)
 
+	// Аутентификация ONVIF (nil — выключена)
+	var authn *auth.Authenticator
+	if cfg.Auth.Enabled {
+		users := func(list []config.UserConfig) []auth.User {
+			out := make([]auth.User, 0, len(list))
+			for _, u := range list {
+				out = append(out, auth.User{Username: u.Username, Password: u.Password})
+			}
+			return out
+		}
+		authCfg := auth.Config{
+			Realm:        cfg.Auth.Realm,
+			ReplayWindow: cfg.Auth.ReplayWindow,
+			Users:        users(cfg.Auth.Users),
+			Devices:      map[string][]auth.User{},
+		}
+		for uid, list := range cfg.Auth.Devices {
+			authCfg.Devices[uid] = users(list)
+		}
+		authn = auth.New(authCfg)
+		log.Printf("[auth] enabled, users=%d, per-device=%d", len(authCfg.Users), len(authCfg.Devices))
+	}
+
 	// 3. Стартуем веб-сервер, передаём statePath
-	sstmkAdapter := sstmk.NewAdapter(cfg.SSTMK.BaseURL)
-	webSrv := web.New(cfg.Web, reg, evbuf, hb, statePath, sstmkAdapter.GetEventService())
+	sstmkAdapter := sstmk.NewAdapter(cfg.SSTMK.BaseURL, onvif.Config{
+		MaxPullTimeout: cfg.Events.MaxPullTimeout,
+		DefaultTTL:     cfg.Events.DefaultTTL,
+		MinTTL:         cfg.Events.MinTTL,
+		MaxTTL:         cfg.Events.MaxTTL,
+		MaxQueue:       cfg.Events.MaxQueue,
+		PushTimeout:    cfg.Events.PushTimeout,
+		PushRetries:    cfg.Events.PushRetries,
+		Auth:           authn,
+	})
+	sstmkAdapter.GetEventService().Start(ctx)
+	// Начальное состояние online: снимок Initialized для первых подписчиков,
+	// дальше — переходы через шину (events.TopicOnline)
+	for _, d := range reg.List() {
+		sstmkAdapter.GetEventService().Publish(onvif.NewDeviceStatusMessage(d.UID, d.Online))
+	}
+	// Удалённое устройство: свойства → Deleted, из снимков пропадают
+	reg.OnChange(func(c registry.Change) {
+		if c.Removed {
+			sstmkAdapter.GetEventService().RemoveDevice(c.Prev.UID)
+		}
+	})
+	// Линия с МК: входы → события, реле ← SetRelayOutputState
+	ttyCfg := tty.Config{
+		Device:   cfg.TTY.Device,
+		BaudRate: cfg.TTY.BaudRate,
+		DataBits: cfg.TTY.DataBits,
+		StopBits: cfg.TTY.StopBits,
+		Parity:   cfg.TTY.Parity,
+		Inputs:   map[int]tty.IO{},
+	}
+	for _, in := range cfg.TTY.Inputs {
+		ttyCfg.Inputs[in.ID] = tty.IO{Device: in.Device, Token: in.Token}
+	}
+	ttyPort := tty.New(ttyCfg)
+
+	// Команды детекторам (SystemReboot, SetSystemFactoryDefault): бинарным — UDP,
+	// остальным — через очередь long-poll ping
+	udpSrv := udp.New(reg, evbuf)
+	commands := devcmd.New(reg, devcmd.HubSender{Hub: hb})
+	commands.Handle("udp", udpSrv)
+	udpSrv.OnComplete(commands.Complete)
+
+	devServers := httpdev.NewSupervisor(cfg, reg, sstmkAdapter.GetEventService(), authn, ttyPort, sstmkAdapter.GetSnapshots(), commands)
+	disc := discovery.New(cfg, reg)
+	webSrv := web.New(cfg.Web, reg, evbuf, hb, statePath, sstmkAdapter.GetEventService(), devServers, commands, disc)
 	go func() {
 		if err := webSrv.Start(ctx); err != nil {
 			errCh <- err
@@ -93,14 +201,16 @@ func main() {
 	}()
 
 	// 4. Стартуем остальные подсистемы
+	stopped := make(chan struct{})
 	go func() {
-		if err := bootstrap.RunAll(ctx, cfg, reg, evbuf); err != nil {
+		defer close(stopped)
+		if err := bootstrap.RunAll(ctx, cfg, reg, evbuf, devServers, disc); err != nil && ctx.Err() == nil {
 			errCh <- err
 		}
 	}()
 
 	go func() {
-		if err := udp.Start(ctx, reg, evbuf); err != nil {
+		if err := udpSrv.Run(ctx); err != nil {
 			log.Printf("UDP server error: %v", err)
 		}
 	}()
@@ -110,14 +220,7 @@ func main() {
 			log.Printf("[tty] disabled")
 			return
 		}
-		ttyCfg := tty.Config{
-			Device:   cfg.TTY.Device,
-			BaudRate: cfg.TTY.BaudRate,
-			DataBits: cfg.TTY.DataBits,
-			StopBits: cfg.TTY.StopBits,
-			Parity:   cfg.TTY.Parity,
-		}
-		if err := tty.Start(ctx, ttyCfg, evbuf); err != nil {
+		if err := ttyPort.Run(ctx, evbuf); err != nil {
 			// не падаем весь сервис, просто логируем
 			log.Printf("tty reader stopped: %v", err)
 		}
@@ -138,6 +241,11 @@ func main() {
 	case err := <-errCh:
 		log.Fatalf("fatal: %v", err)
 	case <-ctx.Done():
-		// graceful shutdown внутри Start/RunAll
+		// graceful shutdown внутри Start/RunAll; ждём RunAll (WS-Discovery Bye)
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			log.Printf("shutdown timeout")
+		}
 	}
 }
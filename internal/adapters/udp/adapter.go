@@ -2,15 +2,36 @@ package udp
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net"
+	"sync"
 	"time"
 
 	"sstmk-onvif/internal/events"
 	"sstmk-onvif/internal/registry"
 )
 
-func Start(ctx context.Context, reg *registry.Store, evbuf events.Buffer) error {
+var ErrNotRunning = errors.New("udp: server is not running")
+
+// Server — UDP-сервер мониторинга детекторов на :50000. Через него же
+// уходят команды устройствам (см. command.go).
+type Server struct {
+	reg   *registry.Store
+	evbuf events.Buffer
+
+	mu      sync.Mutex
+	conn    *net.UDPConn
+	seq     uint32
+	pending map[uint32]pendingCmd // seq → команда
+	done    func(id string, err error)
+}
+
+func New(reg *registry.Store, evbuf events.Buffer) *Server {
+	return &Server{reg: reg, evbuf: evbuf, pending: map[uint32]pendingCmd{}}
+}
+
+func (s *Server) Run(ctx context.Context) error {
 
 	serverAddr, err := net.ResolveUDPAddr("udp", ":50000")
 	if err != nil {
@@ -23,6 +44,15 @@ func Start(ctx context.Context, reg *registry.Store, evbuf events.Buffer) error
 	}
 	defer conn.Close()
 
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+	}()
+
 	broadcastAddr, _ := net.ResolveUDPAddr("udp", "255.255.255.255:50000")
 
 	go func() {
@@ -63,12 +93,12 @@ func Start(ctx context.Context, reg *registry.Store, evbuf events.Buffer) error
 			// Обработка сообщения
 			// важно копировать данные, если handleMessage работает асинхронно,
 			// но если синхронно - можно передавать срез буфера.
-			handleMessage(buf[:n], addr, reg, evbuf)
+			s.handleMessage(buf[:n], addr)
 		}
 	}
 }
 
-func handleMessage(data []byte, addr *net.UDPAddr, reg *registry.Store, evbuf events.Buffer) {
+func (s *Server) handleMessage(data []byte, addr *net.UDPAddr) {
 	if len(data) == 0 {
 		return
 	}
@@ -76,8 +106,10 @@ func handleMessage(data []byte, addr *net.UDPAddr, reg *registry.Store, evbuf ev
 	cmd := data[0]
 	switch cmd {
 	case BP_CMD_DISCOVERY:
-		handleRegistration(data, addr, reg, evbuf)
+		handleRegistration(data, addr, s.reg, s.evbuf)
 	case BP_CMD_EVENT_NOTIFICATION:
-		handleEvent(data, addr, reg, evbuf)
+		handleEvent(data, addr, s.reg, s.evbuf)
+	case BP_CMD_ACK:
+		s.handleAck(data, addr)
 	}
 }
@@ -0,0 +1,105 @@
+package udp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+
+	"sstmk-onvif/internal/devcmd"
+	"sstmk-onvif/internal/registry"
+)
+
+// pendingCmd — отправленная команда, ждущая BP_CMD_ACK.
+type pendingCmd struct {
+	id   string       // ID команды devcmd
+	addr *net.UDPAddr // AdapterDS устройства: ACK принимается только с него
+}
+
+// OnComplete задаёт обработчик подтверждений команд (обычно devcmd.Dispatcher.Complete).
+func (s *Server) OnComplete(f func(id string, err error)) {
+	s.mu.Lock()
+	s.done = f
+	s.mu.Unlock()
+}
+
+// Send отправляет команду на AdapterDS устройства (devcmd.Sender).
+// Подтверждение — BP_CMD_ACK с тем же Seq.
+func (s *Server) Send(d registry.Device, c devcmd.Command) error {
+	pkt := BinaryCommandPacket{}
+	switch c.Type {
+	case devcmd.TypeReboot:
+		pkt.Cmd = BP_CMD_REBOOT
+	case devcmd.TypeFactoryReset:
+		pkt.Cmd = BP_CMD_FACTORY_RESET
+		var p struct {
+			Mode string `json:"mode"`
+		}
+		if len(c.Payload) > 0 {
+			if err := json.Unmarshal(c.Payload, &p); err != nil {
+				return fmt.Errorf("udp: payload: %w", err)
+			}
+		}
+		pkt.Arg = BP_RESET_SOFT
+		if p.Mode == "hard" {
+			pkt.Arg = BP_RESET_HARD
+		}
+	default:
+		return fmt.Errorf("udp: unsupported command %q", c.Type)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", d.AdapterDS)
+	if err != nil {
+		return fmt.Errorf("udp: device %s address %q: %w", d.UID, d.AdapterDS, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return ErrNotRunning
+	}
+	s.seq++
+	pkt.Seq = s.seq
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &pkt)
+	if _, err := s.conn.WriteToUDP(buf.Bytes(), addr); err != nil {
+		return err
+	}
+	s.pending[pkt.Seq] = pendingCmd{id: c.ID, addr: addr}
+	// без ACK запись не удаляется: храним только последние 256 команд
+	delete(s.pending, pkt.Seq-256)
+	log.Printf("[UDP] Команда 0x%02X seq=%d -> %s", pkt.Cmd, pkt.Seq, addr)
+	return nil
+}
+
+func (s *Server) handleAck(data []byte, addr *net.UDPAddr) {
+	var msg BinaryAckPacket
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &msg); err != nil {
+		log.Printf("[UDP] Ошибка парсинга ack от %s: %v", addr, err)
+		return
+	}
+
+	s.mu.Lock()
+	p, ok := s.pending[msg.Seq]
+	if ok && !(p.addr.IP.Equal(addr.IP) && p.addr.Port == addr.Port) {
+		// seq угадывается легко: чужой ACK не завершает команду
+		s.mu.Unlock()
+		log.Printf("[UDP] ack seq=%d от %s, команда отправлена на %s — игнорируем", msg.Seq, addr, p.addr)
+		return
+	}
+	delete(s.pending, msg.Seq)
+	done := s.done
+	s.mu.Unlock()
+	if !ok || done == nil {
+		return
+	}
+
+	var err error
+	if msg.Status != BP_ACK_OK {
+		err = fmt.Errorf("device %s rejected command (status %d)", addr, msg.Status)
+	}
+	done(p.id, err)
+}
@@ -0,0 +1,48 @@
+package udp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func ackPacket(seq uint32, status uint8) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &BinaryAckPacket{Cmd: BP_CMD_ACK, Seq: seq, Status: status})
+	return buf.Bytes()
+}
+
+// ACK завершает команду, только если пришёл с адреса, куда она отправлена.
+func TestHandleAckChecksSender(t *testing.T) {
+	s := New(nil, nil)
+	device := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 50001}
+	s.pending[7] = pendingCmd{id: "cmd-1", addr: device}
+
+	var done []string
+	s.OnComplete(func(id string, err error) {
+		if err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+		done = append(done, id)
+	})
+
+	for _, from := range []*net.UDPAddr{
+		{IP: net.IPv4(192, 168, 1, 99), Port: 50001}, // другой хост
+		{IP: net.IPv4(192, 168, 1, 20), Port: 50002}, // другой порт
+	} {
+		s.handleAck(ackPacket(7, BP_ACK_OK), from)
+	}
+	if len(done) != 0 {
+		t.Fatalf("completed by a foreign ack: %v", done)
+	}
+
+	// тот же адрес в виде IPv4-mapped IPv6, как его отдаёт сокет udp
+	s.handleAck(ackPacket(7, BP_ACK_OK), &net.UDPAddr{IP: net.ParseIP("::ffff:192.168.1.20"), Port: 50001})
+	if len(done) != 1 || done[0] != "cmd-1" {
+		t.Fatalf("done = %v, want [cmd-1]", done)
+	}
+	if _, ok := s.pending[7]; ok {
+		t.Error("pending entry kept after ack")
+	}
+}
@@ -44,7 +44,8 @@ func handleRegistration(data []byte, addr *net.UDPAddr, reg *registry.Store, evb
 		Online:    true,
 	}
 
-	reg.Upsert(dev)
+	// enabled известного устройства задаёт оператор — Register его не трогает
+	reg.Register(dev)
 
 	// Получаем обновленное устройство с назначенным портом
 	if updated, ok := reg.Get(dev.UID); ok {
@@ -94,12 +95,13 @@ func handleEvent(data []byte, addr *net.UDPAddr, reg *registry.Store, evbuf even
 	}
 
 	// Формирование Payload для системы (JSON с данными + картинка)
-	payloadMap := map[string]interface{}{
-		"data":  msg,
-		"image": base64Image,
+	payload := events.DetectorPayload{
+		Data:     msg,
+		Image:    base64Image,
+		Detector: detectorEvent(&msg),
 	}
 
-	jsonBytes, err := json.Marshal(payloadMap)
+	jsonBytes, err := json.Marshal(payload)
 	if err != nil {
 		log.Printf("[UDP] Ошибка формирования JSON: %v", err)
 		return
@@ -108,7 +110,7 @@ func handleEvent(data []byte, addr *net.UDPAddr, reg *registry.Store, evbuf even
 	// Отправка в шину событий
 	evbuf.Push(events.Event{
 		DeviceID: deviceID,
-		Topic:    "detector/event",
+		Topic:    events.TopicDetector,
 		Payload:  jsonBytes,
 		Time:     time.Now(),
 	})
@@ -123,3 +125,28 @@ func handleEvent(data []byte, addr *net.UDPAddr, reg *registry.Store, evbuf even
 	}
 
 }
+
+// detectorEvent переводит бинарный пакет в типизированное событие шины.
+func detectorEvent(msg *BinaryEventPacket) events.DetectorEvent {
+	st := &msg.Status
+	ev := events.DetectorEvent{
+		Classification: events.Classification{
+			Type:   st.Classification.Type,
+			Class:  st.Classification.Class,
+			Object: st.Classification.Object,
+		},
+		In:        st.In,
+		Out:       st.Out,
+		Inside:    st.Inside,
+		AlarmsIn:  st.Metal.AlarmsIn,
+		AlarmsOut: st.Metal.AlarmsOut,
+		Zones:     make([][]events.Zone, N_COILS_PER_SIDE),
+	}
+	for r := 0; r < N_COILS_PER_SIDE; r++ {
+		ev.Zones[r] = make([]events.Zone, N_COIL_SIDES)
+		for c := 0; c < N_COIL_SIDES; c++ {
+			ev.Zones[r][c] = events.Zone{Level: msg.Zones.Level[r][c], Cnt: msg.Zones.Cnt[r][c]}
+		}
+	}
+	return ev
+}
@@ -4,9 +4,37 @@ package udp
 const (
 	BP_CMD_DISCOVERY          uint8 = 0x00 // Discovery Request
 	BP_CMD_EVENT_NOTIFICATION uint8 = 0x05 // Уведомление о событии
+	BP_CMD_REBOOT             uint8 = 0x06 // Перезагрузка
+	BP_CMD_FACTORY_RESET      uint8 = 0x07 // Сброс к заводским настройкам
 	BP_CMD_ACK                uint8 = 0xFF
 )
 
+// Режим BP_CMD_FACTORY_RESET
+const (
+	BP_RESET_SOFT uint8 = 0 // сохранить сетевые настройки
+	BP_RESET_HARD uint8 = 1 // сбросить всё
+)
+
+// Статус в BinaryAckPacket
+const (
+	BP_ACK_OK  uint8 = 0
+	BP_ACK_ERR uint8 = 1
+)
+
+// BinaryCommandPacket — BP_CMD_REBOOT / BP_CMD_FACTORY_RESET (Arg — режим сброса).
+type BinaryCommandPacket struct {
+	Cmd uint8
+	Seq uint32
+	Arg uint8
+}
+
+// BinaryAckPacket — ответ устройства на команду с тем же Seq.
+type BinaryAckPacket struct {
+	Cmd    uint8
+	Seq    uint32
+	Status uint8
+}
+
 type BinaryDiscoveryPacket struct {
 	Cmd      uint8
 	SN       [32]byte
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sstmk-onvif/internal/soap"
+)
+
+var (
+	ErrNoCredentials  = errors.New("auth: no credentials")
+	ErrUnknownUser    = errors.New("auth: unknown user")
+	ErrBadPassword    = errors.New("auth: password mismatch")
+	ErrUnsupported    = errors.New("auth: unsupported password type")
+	ErrStale          = errors.New("auth: timestamp or nonce outside replay window")
+	ErrReplay         = errors.New("auth: nonce already used")
+	ErrMalformedToken = errors.New("auth: malformed credentials")
+)
+
+const (
+	defaultRealm        = "sstmk-onvif"
+	defaultReplayWindow = 5 * time.Minute
+)
+
+// User — учётная запись ONVIF-клиента.
+type User struct {
+	Username string
+	Password string
+}
+
+// Config — настройки проверки учётных данных.
+type Config struct {
+	Realm        string            // realm для HTTP Digest
+	ReplayWindow time.Duration     // допустимое отклонение Created и срок жизни nonce
+	Users        []User            // учётки для всех устройств
+	Devices      map[string][]User // дополнительные учётки по UID устройства
+}
+
+// Authenticator проверяет WS-Security UsernameToken (PasswordDigest)
+// и, если его нет, HTTP Digest. nil-Authenticator пропускает всё —
+// так выглядит выключенная аутентификация.
+type Authenticator struct {
+	cfg    Config
+	secret []byte // ключ подписи nonce для HTTP Digest
+
+	mu   sync.Mutex
+	seen map[string]time.Time // использованные nonce → когда забыть
+}
+
+func New(cfg Config) *Authenticator {
+	if cfg.Realm == "" {
+		cfg.Realm = defaultRealm
+	}
+	if cfg.ReplayWindow <= 0 {
+		cfg.ReplayWindow = defaultReplayWindow
+	}
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return &Authenticator{cfg: cfg, secret: secret, seen: map[string]time.Time{}}
+}
+
+// Check проверяет учётные данные запроса к устройству deviceID
+// ("" — только общие учётки). env может быть nil, если конверт не разобран.
+func (a *Authenticator) Check(r *http.Request, env *soap.Envelope, deviceID string) error {
+	if a == nil {
+		return nil
+	}
+	if env != nil && env.UsernameToken != nil {
+		return a.checkUsernameToken(env.UsernameToken, deviceID, time.Now())
+	}
+	if h := r.Header.Get("Authorization"); h != "" {
+		return a.checkDigest(r, h, deviceID, time.Now())
+	}
+	return ErrNoCredentials
+}
+
+// password ищет пароль пользователя: сначала среди учёток устройства,
+// затем среди общих.
+func (a *Authenticator) password(username, deviceID string) (string, bool) {
+	for _, u := range a.cfg.Devices[deviceID] {
+		if u.Username == username {
+			return u.Password, true
+		}
+	}
+	for _, u := range a.cfg.Users {
+		if u.Username == username {
+			return u.Password, true
+		}
+	}
+	return "", false
+}
+
+// checkUsernameToken: Digest = Base64(SHA1(nonce + created + password)).
+func (a *Authenticator) checkUsernameToken(tok *soap.UsernameToken, deviceID string, now time.Time) error {
+	if t := strings.TrimSpace(tok.Password.Type); t != "" && t != soap.PasswordDigest {
+		return ErrUnsupported
+	}
+	username := strings.TrimSpace(tok.Username)
+	nonce, err := base64.StdEncoding.DecodeString(strings.TrimSpace(tok.Nonce))
+	if err != nil || len(nonce) == 0 {
+		return ErrMalformedToken
+	}
+	createdStr := strings.TrimSpace(tok.Created)
+	created, err := time.Parse(time.RFC3339Nano, createdStr)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	if d := now.Sub(created); d > a.cfg.ReplayWindow || d < -a.cfg.ReplayWindow {
+		return ErrStale
+	}
+
+	pass, ok := a.password(username, deviceID)
+	if !ok {
+		return ErrUnknownUser
+	}
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(createdStr))
+	h.Write([]byte(pass))
+	want := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(want), []byte(strings.TrimSpace(tok.Password.Value))) != 1 {
+		return ErrBadPassword
+	}
+	return a.remember("wsse|"+username+"|"+string(nonce), now)
+}
+
+// remember отмечает nonce использованным; повтор в пределах окна — ErrReplay.
+func (a *Authenticator) remember(key string, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for k, until := range a.seen {
+		if now.After(until) {
+			delete(a.seen, k)
+		}
+	}
+	if _, dup := a.seen[key]; dup {
+		return ErrReplay
+	}
+	// Created может быть до 2×окна в прошлом относительно истечения
+	a.seen[key] = now.Add(2 * a.cfg.ReplayWindow)
+	return nil
+}
+
+// Deny отвечает на запрос без допустимых учётных данных SOAP-fault
+// ter:NotAuthorized. Если клиент не пытался представиться или использовал
+// HTTP Digest — 401 с вызовом WWW-Authenticate: Digest.
+// env — разобранный запрос (для RelatesTo), может быть nil.
+func (a *Authenticator) Deny(w http.ResponseWriter, r *http.Request, env *soap.Envelope, err error) {
+	fault := soap.NotAuthorized()
+	if errors.Is(err, ErrNoCredentials) || r.Header.Get("Authorization") != "" {
+		w.Header().Set("WWW-Authenticate", a.challenge(time.Now(), errors.Is(err, ErrStale)))
+		fault.Status = http.StatusUnauthorized
+	}
+	log.Printf("auth: %s %s from %s denied: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+	soap.WriteFault(w, env, fault)
+}
+
+// signNonce — подпись метки времени nonce для HTTP Digest.
+func (a *Authenticator) signNonce(ts string) string {
+	m := hmac.New(sha1.New, a.secret)
+	m.Write([]byte(ts))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"sstmk-onvif/internal/soap"
+)
+
+// usernameToken собирает PasswordDigest, как его считает клиент.
+func usernameToken(user, pass string, nonce []byte, created string) *soap.UsernameToken {
+	h := sha1.New()
+	h.Write(nonce)
+	h.Write([]byte(created))
+	h.Write([]byte(pass))
+	tok := &soap.UsernameToken{
+		Username: user,
+		Nonce:    base64.StdEncoding.EncodeToString(nonce),
+		Created:  created,
+	}
+	tok.Password.Type = soap.PasswordDigest
+	tok.Password.Value = base64.StdEncoding.EncodeToString(h.Sum(nil))
+	return tok
+}
+
+func TestCheckUsernameToken(t *testing.T) {
+	a := New(Config{
+		ReplayWindow: time.Minute,
+		Users:        []User{{Username: "admin", Password: "secret"}},
+	})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	created := now.Add(-5 * time.Second).Format(time.RFC3339)
+
+	textPassword := usernameToken("admin", "secret", []byte("n3"), created)
+	textPassword.Password.Type = soap.PasswordText
+	textPassword.Password.Value = "secret"
+	badNonce := usernameToken("admin", "secret", []byte("n4"), created)
+	badNonce.Nonce = "%%%"
+
+	tests := []struct {
+		name string
+		tok  *soap.UsernameToken
+		want error
+	}{
+		{"valid", usernameToken("admin", "secret", []byte("n1"), created), nil},
+		{"replayed nonce", usernameToken("admin", "secret", []byte("n1"), created), ErrReplay},
+		{"fractional Created", usernameToken("admin", "secret", []byte("n2"), now.Format("2006-01-02T15:04:05.000Z")), nil},
+		{"Created too old", usernameToken("admin", "secret", []byte("n5"), now.Add(-2*time.Minute).Format(time.RFC3339)), ErrStale},
+		{"Created in the future", usernameToken("admin", "secret", []byte("n6"), now.Add(2*time.Minute).Format(time.RFC3339)), ErrStale},
+		{"bad Created", usernameToken("admin", "secret", []byte("n7"), "yesterday"), ErrMalformedToken},
+		{"bad nonce encoding", badNonce, ErrMalformedToken},
+		{"empty nonce", usernameToken("admin", "secret", nil, created), ErrMalformedToken},
+		{"PasswordText", textPassword, ErrUnsupported},
+		{"wrong password", usernameToken("admin", "guess", []byte("n8"), created), ErrBadPassword},
+		{"unknown user", usernameToken("guest", "secret", []byte("n9"), created), ErrUnknownUser},
+	}
+	for _, tt := range tests {
+		if err := a.checkUsernameToken(tt.tok, "", now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: checkUsernameToken = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCheckNil(t *testing.T) {
+	var a *Authenticator
+	r := httptest.NewRequest("POST", "/onvif/device_service", nil)
+	if err := a.Check(r, nil, ""); err != nil {
+		t.Errorf("nil Authenticator: %v", err)
+	}
+	if err := New(Config{}).Check(r, nil, ""); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("no credentials: %v", err)
+	}
+}
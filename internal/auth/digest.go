@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// challenge — значение WWW-Authenticate. nonce = "<unix>.<hmac>":
+// сервер не хранит выданные nonce, а проверяет подпись и возраст.
+func (a *Authenticator) challenge(now time.Time, stale bool) string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	c := fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=MD5, nonce="%s.%s"`,
+		a.cfg.Realm, ts, a.signNonce(ts))
+	if stale {
+		c += `, stale=true`
+	}
+	return c
+}
+
+// checkDigest проверяет заголовок Authorization: Digest (RFC 7616, MD5).
+func (a *Authenticator) checkDigest(r *http.Request, header, deviceID string, now time.Time) error {
+	scheme, rest, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return ErrUnsupported
+	}
+	p := parseDigestParams(rest)
+	if p["username"] == "" || p["nonce"] == "" || p["response"] == "" || p["realm"] != a.cfg.Realm {
+		return ErrMalformedToken
+	}
+	if alg := p["algorithm"]; alg != "" && !strings.EqualFold(alg, "MD5") {
+		return ErrUnsupported
+	}
+	if p["uri"] != r.URL.RequestURI() {
+		return ErrMalformedToken
+	}
+
+	ts, sig, ok := strings.Cut(p["nonce"], ".")
+	if !ok || subtle.ConstantTimeCompare([]byte(sig), []byte(a.signNonce(ts))) != 1 {
+		return ErrStale
+	}
+	issued, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || now.Sub(time.Unix(issued, 0)) > a.cfg.ReplayWindow {
+		return ErrStale
+	}
+
+	pass, ok := a.password(p["username"], deviceID)
+	if !ok {
+		return ErrUnknownUser
+	}
+	ha1 := md5hex(p["username"] + ":" + a.cfg.Realm + ":" + pass)
+	ha2 := md5hex(r.Method + ":" + p["uri"])
+	var want string
+	switch p["qop"] {
+	case "auth":
+		want = md5hex(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+	case "":
+		want = md5hex(ha1 + ":" + p["nonce"] + ":" + ha2)
+	default:
+		return ErrUnsupported
+	}
+	if subtle.ConstantTimeCompare([]byte(want), []byte(strings.ToLower(p["response"]))) != 1 {
+		return ErrBadPassword
+	}
+	return a.remember("digest|"+p["nonce"]+"|"+p["nc"]+"|"+p["cnonce"], now)
+}
+
+func md5hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseDigestParams разбирает список key=value / key="value" через запятую.
+func parseDigestParams(s string) map[string]string {
+	out := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimSpace(s[eq+1:])
+
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			// quoted-string: \x — литеральный x
+			var b strings.Builder
+			end := 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' && end+1 < len(s) {
+					end++
+				}
+				b.WriteByte(s[end])
+			}
+			val = b.String()
+			s = s[min(end+1, len(s)):]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			val = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		out[key] = val
+		s = strings.TrimPrefix(strings.TrimSpace(s), ",")
+	}
+	return out
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDigestParams(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+	}{
+		{
+			in: `username="admin", realm="sstmk-onvif", nonce="1.abc", uri="/onvif/device_service", qop=auth, nc=00000001, cnonce="xyz", response="0123"`,
+			want: map[string]string{
+				"username": "admin", "realm": "sstmk-onvif", "nonce": "1.abc", "uri": "/onvif/device_service",
+				"qop": "auth", "nc": "00000001", "cnonce": "xyz", "response": "0123",
+			},
+		},
+		{
+			in:   `Username = "a" ,REALM=r`,
+			want: map[string]string{"username": "a", "realm": "r"},
+		},
+		{
+			in:   `uri="/a,b", cnonce="x"`,
+			want: map[string]string{"uri": "/a,b", "cnonce": "x"},
+		},
+		{
+			in:   `username="a\"b"`,
+			want: map[string]string{"username": `a"b`},
+		},
+		{
+			// quoted-pair: обратная косая черта экранирует сама себя
+			in:   `username="dom\\user", realm="c\d"`,
+			want: map[string]string{"username": `dom\user`, "realm": "cd"},
+		},
+		{
+			in:   `username="unterminated`,
+			want: map[string]string{"username": "unterminated"},
+		},
+		{
+			in:   `username=`,
+			want: map[string]string{"username": ""},
+		},
+		{
+			in:   `garbage without equals`,
+			want: map[string]string{},
+		},
+		{
+			in:   ``,
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		if got := parseDigestParams(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseDigestParams(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// digestHeader собирает Authorization: Digest, как его считает клиент.
+func digestHeader(user, pass, realm, method, uri, nonce, qop, nc, cnonce string) string {
+	ha1 := md5hex(user + ":" + realm + ":" + pass)
+	ha2 := md5hex(method + ":" + uri)
+	resp := md5hex(ha1 + ":" + nonce + ":" + ha2)
+	h := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, user, realm, nonce, uri)
+	if qop != "" {
+		resp = md5hex(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+		h += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	return h + fmt.Sprintf(`, response="%s"`, resp)
+}
+
+func TestCheckDigest(t *testing.T) {
+	a := New(Config{
+		Realm:        "sstmk-onvif",
+		ReplayWindow: time.Minute,
+		Users:        []User{{Username: "admin", Password: "secret"}},
+		Devices:      map[string][]User{"gate-002": {{Username: "op", Password: "op2"}}},
+	})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	const uri = "/onvif/device_service"
+
+	nonceAt := func(t time.Time) string {
+		ts := strconv.FormatInt(t.Unix(), 10)
+		return ts + "." + a.signNonce(ts)
+	}
+	fresh := nonceAt(now.Add(-10 * time.Second))
+
+	tests := []struct {
+		name   string
+		header string
+		device string
+		want   error
+	}{
+		{
+			name:   "qop auth",
+			header: digestHeader("admin", "secret", "sstmk-onvif", "POST", uri, fresh, "auth", "00000001", "c1"),
+		},
+		{
+			name:   "no qop (RFC 2069)",
+			header: digestHeader("admin", "secret", "sstmk-onvif", "POST", uri, fresh, "", "", ""),
+		},
+		{
+			name:   "same nonce, next nc",
+			header: digestHeader("admin", "secret", "sstmk-onvif", "POST", uri, fresh, "auth", "00000002", "c1"),
+		},
+		{
+			name:   "replayed nc and cnonce",
+			header: digestHeader("admin", "secret", "sstmk-onvif", "POST", uri, fresh, "auth", "00000001", "c1"),
+			want:   ErrReplay,
+		},
+		{
+			name:   "device user on its device",
+			header: digestHeader("op", "op2", "sstmk-onvif", "POST", uri, fresh, "auth", "00000001", "c2"),
+			device: "gate-002",
+		},
+		{
+			name:   "device user on another device",
+			header: digestHeader("op", "op2", "sstmk-onvif", "POST", uri, fresh, "auth", "00000001", "c3"),
+			device: "gate-001",
+			want:   ErrUnknownUser,
+		},
+		{
+			name:   "stale nonce",
+			header: digestHeader("admin", "secret", "sstmk-onvif", "POST", uri, nonceAt(now.Add(-2*time.Minute)), "auth", "00000001", "c4"),
+			want:   ErrStale,
+		},
+		{
+			name:   "forged nonce signature",
+			header: digestHeader("admin", "secret", "sstmk-onvif", "POST", uri, strconv.FormatInt(now.Unix(), 10)+".forged", "auth", "00000001", "c5"),
+			want:   ErrStale,
+		},
+		{
+			name:   "nonce without signature",
+			header: digestHeader("admin", "secret", "sstmk-onvif", "POST", uri, strconv.FormatInt(now.Unix(), 10), "auth", "00000001", "c6"),
+			want:   ErrStale,
+		},
+		{
+			name:   "wrong password",
+			header: digestHeader("admin", "guess", "sstmk-onvif", "POST", uri, fresh, "auth", "00000001", "c7"),
+			want:   ErrBadPassword,
+		},
+		{
+			name:   "wrong realm",
+			header: digestHeader("admin", "secret", "other", "POST", uri, fresh, "auth", "00000001", "c8"),
+			want:   ErrMalformedToken,
+		},
+		{
+			name:   "uri of another resource",
+			header: digestHeader("admin", "secret", "sstmk-onvif", "POST", "/onvif/events", fresh, "auth", "00000001", "c9"),
+			want:   ErrMalformedToken,
+		},
+		{
+			name:   "method mismatch",
+			header: digestHeader("admin", "secret", "sstmk-onvif", "GET", uri, fresh, "auth", "00000001", "c10"),
+			want:   ErrBadPassword,
+		},
+		{
+			name:   "unsupported qop",
+			header: strings.Replace(digestHeader("admin", "secret", "sstmk-onvif", "POST", uri, fresh, "auth", "00000001", "c11"), "qop=auth", "qop=auth-int", 1),
+			want:   ErrUnsupported,
+		},
+		{
+			name:   "unsupported algorithm",
+			header: digestHeader("admin", "secret", "sstmk-onvif", "POST", uri, fresh, "auth", "00000001", "c12") + ", algorithm=SHA-256",
+			want:   ErrUnsupported,
+		},
+		{
+			name:   "basic scheme",
+			header: "Basic YWRtaW46c2VjcmV0",
+			want:   ErrUnsupported,
+		},
+		{
+			name:   "missing response",
+			header: `Digest username="admin", realm="sstmk-onvif", nonce="` + fresh + `", uri="` + uri + `"`,
+			want:   ErrMalformedToken,
+		},
+	}
+	// подтесты идут по порядку: повтор проверяется после первого запроса
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", uri, nil)
+			if err := a.checkDigest(r, tt.header, tt.device, now); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// Ответ на вызов сервера принимается один раз; повтор — ErrReplay,
+// после окна nonce — ErrStale (клиент должен взять новый вызов).
+func TestDigestChallenge(t *testing.T) {
+	a := New(Config{ReplayWindow: time.Minute, Users: []User{{Username: "admin", Password: "secret"}}})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	ch := a.challenge(now, true)
+	if !strings.Contains(ch, `realm="sstmk-onvif"`) || !strings.HasSuffix(ch, "stale=true") {
+		t.Fatalf("challenge = %q", ch)
+	}
+	nonce := parseDigestParams(strings.TrimPrefix(ch, "Digest "))["nonce"]
+	h := digestHeader("admin", "secret", "sstmk-onvif", "POST", "/onvif/device_service", nonce, "auth", "00000001", "c")
+
+	steps := []struct {
+		at   time.Duration
+		want error
+	}{
+		{0, nil},
+		{30 * time.Second, ErrReplay},
+		{90 * time.Second, ErrStale}, // nonce старше окна
+	}
+	for _, s := range steps {
+		r := httptest.NewRequest("POST", "/onvif/device_service", nil)
+		if err := a.checkDigest(r, h, "", now.Add(s.at)); !errors.Is(err, s.want) {
+			t.Errorf("at +%v: checkDigest = %v, want %v", s.at, err, s.want)
+		}
+	}
+}
@@ -22,7 +22,7 @@ func (s *sinkImpl) OnRaw(deviceID string, payload []byte) {
 	s.buf.Push(events.Event{DeviceID: deviceID, Topic: "raw", Payload: payload, Time: time.Now()})
 }
 
-func RunAll(ctx context.Context, cfg *config.Config, reg *registry.Store, buf events.Buffer) error {
+func RunAll(ctx context.Context, cfg *config.Config, reg *registry.Store, buf events.Buffer, devices *httpdev.Supervisor, disc *discovery.Service) error {
 	// роутинг адаптеров
 	factoryMap := map[string]adapters.Factory{
 		"tcp": tcp.New,
@@ -32,18 +32,23 @@ func RunAll(ctx context.Context, cfg *config.Config, reg *registry.Store, buf ev
 	go reg.StartMonitoring(ctx, 30*time.Second)
 	log.Printf("[Bootstrap] Device monitoring started (interval: 30s)")
 
-	// 2) поднимаем HTTP-серверы для каждого устройства
-	if err := httpdev.StartAll(ctx, cfg, reg); err != nil {
-		return err
-	}
+	// 2) HTTP-серверы устройств: следуют за изменениями реестра
+	go func() {
+		if err := devices.Run(ctx); err != nil {
+			log.Printf("[Bootstrap] device servers: %v", err)
+		}
+	}()
 
-	// 3) WS-Discovery
-	if err := discovery.Start(ctx, cfg, reg); err != nil {
-		return err
-	}
+	var wg sync.WaitGroup
+
+	// 3) WS-Discovery: ждём его при остановке, чтобы успели уйти Bye
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		disc.Run(ctx)
+	}()
 
 	// 4) Адаптеры (получение сырых данных)
-	var wg sync.WaitGroup
 	sink := &sinkImpl{buf: buf}
 	for _, m := range reg.List() {
 		f, ok := factoryMap[m.Adapter]
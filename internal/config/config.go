@@ -3,8 +3,12 @@ package config
 import (
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
 	"sstmk-onvif/internal/registry"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -36,6 +40,16 @@ type TTYConfig struct {
 	DataBits int    `yaml:"databits"` // 7, 8
 	StopBits int    `yaml:"stopbits"` // 1, 2
 	Parity   string `yaml:"parity"`   // "none", "odd", "even"
+
+	Inputs []TTYIOConfig `yaml:"inputs"` // входы EVT,<id>,<state> → цифровые входы устройств
+	Relays []TTYIOConfig `yaml:"relays"` // релейные выходы OUT,<id>,<state>
+}
+
+// TTYIOConfig привязывает вход или реле МК к устройству.
+type TTYIOConfig struct {
+	ID     int    `yaml:"id"`     // номер в протоколе TTY
+	Device string `yaml:"device"` // UID устройства
+	Token  string `yaml:"token"`  // токен ONVIF; пусто — DI_<id> / RO_<id>
 }
 
 type SSTMKConfig struct {
@@ -43,17 +57,105 @@ type SSTMKConfig struct {
 	BaseURL string `yaml:"base_url"`
 }
 
+type EventsConfig struct {
+	MaxPullTimeout time.Duration `yaml:"max_pull_timeout"` // предел Timeout в PullMessages
+	DefaultTTL     time.Duration `yaml:"default_ttl"`      // срок подписки по умолчанию
+	MinTTL         time.Duration `yaml:"min_ttl"`          // нижняя граница срока подписки
+	MaxTTL         time.Duration `yaml:"max_ttl"`          // верхняя граница срока подписки
+	MaxQueue       int           `yaml:"max_queue"`        // очередь сообщений на подписку
+	PushTimeout    time.Duration `yaml:"push_timeout"`     // таймаут Notify для push-подписок
+	PushRetries    int           `yaml:"push_retries"`     // попыток доставки Notify
+}
+
+type UserConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type AuthConfig struct {
+	Enabled      bool                    `yaml:"enabled"`
+	Realm        string                  `yaml:"realm"`         // realm для HTTP Digest
+	ReplayWindow time.Duration           `yaml:"replay_window"` // допуск wsu:Created и срок жизни nonce
+	Users        []UserConfig            `yaml:"users"`         // учётки для всех устройств
+	Devices      map[string][]UserConfig `yaml:"devices"`       // дополнительные учётки по id устройства
+}
+
+// Режимы раздачи ONVIF-сервисов устройств.
+const (
+	ModePerPort    = "per_port"    // свой порт у каждого устройства (9005–9230)
+	ModeSinglePort = "single_port" // один порт, устройство выбирается путём /onvif/{uid}/...
+)
+
+type ONVIFConfig struct {
+	Mode string `yaml:"mode"` // per_port | single_port
+	Port int    `yaml:"port"` // порт единого listener в режиме single_port
+}
+
 type Config struct {
 	PublicIP     string        `yaml:"public_ip"`
 	LANIfName    string        `yaml:"lan_if"`
 	DevicePath   string        `yaml:"device_path"`
 	EventsPath   string        `yaml:"events_path"`
+	SnapshotPath string        `yaml:"snapshot_path"`
 	Devices      []Device      `yaml:"devices"`
 	ReadTimeout  time.Duration `yaml:"read_timeout"`
 	WriteTimeout time.Duration `yaml:"write_timeout"`
 	Web          WebConfig     `yaml:"web"`
 	TTY          TTYConfig     `yaml:"tty"`
 	SSTMK        SSTMKConfig   `yaml:"sstmk"`
+	Events       EventsConfig  `yaml:"events"`
+	Auth         AuthConfig    `yaml:"auth"`
+	ONVIF        ONVIFConfig   `yaml:"onvif"`
+
+	// Адрес для XAddrs по интерфейсу, через который пришёл запрос WS-Discovery
+	// (имя интерфейса → IP/хост); важнее public_ip
+	AdvertisedHosts map[string]string `yaml:"advertised_hosts"`
+}
+
+// SinglePort — все устройства раздаются с одного порта ONVIF.Port.
+func (c *Config) SinglePort() bool {
+	return c.ONVIF.Mode == ModeSinglePort
+}
+
+// servicePath — путь p на listener устройства uid. В режиме single_port
+// перед путём стоит /onvif/{uid}: /onvif/device_service → /onvif/{uid}/device_service.
+func (c *Config) servicePath(uid, p string) string {
+	if !c.SinglePort() {
+		return p
+	}
+	return "/onvif/" + uid + strings.TrimPrefix(p, "/onvif")
+}
+
+// ServicePaths — пути Device Service и сервиса событий устройства uid.
+func (c *Config) ServicePaths(uid string) (devicePath, eventsPath string) {
+	return c.servicePath(uid, c.DevicePath), c.servicePath(uid, c.EventsPath)
+}
+
+// SnapshotPathFor — путь картинки зон устройства uid (ONVIF GetSnapshotUri).
+func (c *Config) SnapshotPathFor(uid string) string {
+	return c.servicePath(uid, c.SnapshotPath)
+}
+
+// baseURL — http://host:port listener устройства d.
+func (c *Config) baseURL(host string, d Device) string {
+	port := d.Port
+	if c.SinglePort() {
+		port = strconv.Itoa(c.ONVIF.Port)
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// XAddrs — адреса Device Service и сервиса событий устройства d
+// для клиента, обращающегося к host.
+func (c *Config) XAddrs(host string, d Device) (deviceXAddr, eventsXAddr string) {
+	base := c.baseURL(host, d)
+	devicePath, eventsPath := c.ServicePaths(url.PathEscape(d.UID))
+	return base + devicePath, base + eventsPath
+}
+
+// SnapshotURI — адрес картинки зон устройства d.
+func (c *Config) SnapshotURI(host string, d Device) string {
+	return c.baseURL(host, d) + c.SnapshotPathFor(url.PathEscape(d.UID))
 }
 
 func Load() (*Config, error) {
@@ -88,6 +190,23 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("cannot parse yaml %s: %w", path, err)
 	}
 
+	for i := range cfg.TTY.Inputs {
+		if cfg.TTY.Inputs[i].Token == "" {
+			cfg.TTY.Inputs[i].Token = fmt.Sprintf("DI_%d", cfg.TTY.Inputs[i].ID)
+		}
+	}
+	for i := range cfg.TTY.Relays {
+		if cfg.TTY.Relays[i].Token == "" {
+			cfg.TTY.Relays[i].Token = fmt.Sprintf("RO_%d", cfg.TTY.Relays[i].ID)
+		}
+	}
+
+	switch cfg.ONVIF.Mode {
+	case ModePerPort, ModeSinglePort:
+	default:
+		return nil, fmt.Errorf("onvif.mode: unknown mode %q (want %s or %s)", cfg.ONVIF.Mode, ModePerPort, ModeSinglePort)
+	}
+
 	log.Printf("config loaded, devices=%d", len(cfg.Devices))
 	for i, dev := range cfg.Devices {
 		log.Printf("Config Device %d: UID=%s, Name=%s, Vendor=%s, Serial=%s, Firmware=%s", i, dev.UID, dev.Name, dev.Vendor, dev.SerialNumber, dev.Version)
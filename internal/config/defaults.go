@@ -11,6 +11,7 @@ func Defaults() *Config {
 		LANIfName:    "br-lan",
 		DevicePath:   "/onvif/device_service",
 		EventsPath:   "/onvif/events",
+		SnapshotPath: "/onvif/snapshot.png",
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 
@@ -28,5 +29,26 @@ func Defaults() *Config {
 			StopBits: 1,
 			Parity:   "none",
 		},
+
+		Events: EventsConfig{
+			MaxPullTimeout: 60 * time.Second,
+			DefaultTTL:     24 * time.Hour,
+			MinTTL:         10 * time.Second,
+			MaxTTL:         24 * time.Hour,
+			MaxQueue:       100,
+			PushTimeout:    5 * time.Second,
+			PushRetries:    5,
+		},
+
+		Auth: AuthConfig{
+			Enabled:      false,
+			Realm:        "sstmk-onvif",
+			ReplayWindow: 5 * time.Minute,
+		},
+
+		ONVIF: ONVIFConfig{
+			Mode: ModePerPort,
+			Port: 8000,
+		},
 	}
 }
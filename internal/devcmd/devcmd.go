@@ -0,0 +1,181 @@
+package devcmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"sstmk-onvif/internal/registry"
+)
+
+// Типы команд
+const (
+	TypeReboot       = "reboot"
+	TypeFactoryReset = "factory_reset" // payload: {"mode":"hard"|"soft"}
+)
+
+// Status — стадия выполнения команды.
+type Status string
+
+const (
+	StatusSent    Status = "sent"    // передана адаптеру, ждём подтверждения
+	StatusDone    Status = "done"    // устройство подтвердило
+	StatusFailed  Status = "failed"  // устройство или адаптер вернули ошибку
+	StatusTimeout Status = "timeout" // подтверждения не было за Timeout
+)
+
+var (
+	ErrNoDevice  = errors.New("devcmd: device not found")
+	ErrNoAdapter = errors.New("devcmd: adapter cannot deliver commands")
+)
+
+// Command — команда устройству и её состояние.
+type Command struct {
+	ID       string          `json:"id"`
+	DeviceID string          `json:"device_id"`
+	Type     string          `json:"type"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Status   Status          `json:"status"`
+	Error    string          `json:"error,omitempty"`
+	Created  time.Time       `json:"created"`
+	Updated  time.Time       `json:"updated"`
+}
+
+// Sender доставляет команду устройству d. Подтверждение приходит
+// позже через Dispatcher.Complete.
+type Sender interface {
+	Send(d registry.Device, c Command) error
+}
+
+const (
+	defaultTimeout = 2 * time.Minute
+	keepFinished   = time.Hour // сколько помнить завершённые команды
+)
+
+// Dispatcher выбирает Sender по адаптеру устройства (registry.Device.Adapter)
+// и отслеживает выполнение команд.
+type Dispatcher struct {
+	reg      *registry.Store
+	senders  map[string]Sender
+	fallback Sender
+
+	mu   sync.Mutex
+	cmds map[string]*Command
+}
+
+// New — fallback доставляет команды устройствам, для адаптера которых
+// нет своего Sender (HTTP-ping устройства через hub).
+func New(reg *registry.Store, fallback Sender) *Dispatcher {
+	return &Dispatcher{reg: reg, senders: map[string]Sender{}, fallback: fallback, cmds: map[string]*Command{}}
+}
+
+// Handle назначает Sender для адаптера (например, "udp").
+func (d *Dispatcher) Handle(adapter string, s Sender) {
+	d.senders[adapter] = s
+}
+
+// Submit отправляет команду устройству deviceID.
+func (d *Dispatcher) Submit(deviceID, typ string, payload any) (Command, error) {
+	dev, ok := d.reg.Get(deviceID)
+	if !ok {
+		return Command{}, ErrNoDevice
+	}
+	s, ok := d.senders[dev.Adapter]
+	if !ok {
+		s = d.fallback
+	}
+	if s == nil {
+		return Command{}, ErrNoAdapter
+	}
+
+	now := time.Now()
+	c := Command{ID: uuid.New().String(), DeviceID: deviceID, Type: typ, Status: StatusSent, Created: now, Updated: now}
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return Command{}, fmt.Errorf("devcmd: payload: %w", err)
+		}
+		c.Payload = b
+	}
+
+	// запоминаем до отправки: подтверждение может прийти раньше возврата Send;
+	// старые команды забываем и здесь — список могут не читать вовсе
+	d.mu.Lock()
+	d.expire(now)
+	d.cmds[c.ID] = &c
+	d.mu.Unlock()
+
+	if err := s.Send(dev, c); err != nil {
+		d.Complete(c.ID, err)
+		return d.mustGet(c.ID), err
+	}
+	log.Printf("devcmd: %s %s -> %s (%s)", c.ID, typ, deviceID, dev.Adapter)
+	return d.mustGet(c.ID), nil
+}
+
+// Complete отмечает команду выполненной (err == nil) или неудачной.
+// Неизвестные и уже завершённые команды игнорируются.
+func (d *Dispatcher) Complete(id string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.cmds[id]
+	if !ok || c.Status != StatusSent {
+		return
+	}
+	c.Status, c.Updated = StatusDone, time.Now()
+	if err != nil {
+		c.Status, c.Error = StatusFailed, err.Error()
+	}
+	log.Printf("devcmd: %s %s on %s: %s %s", c.ID, c.Type, c.DeviceID, c.Status, c.Error)
+}
+
+// expire переводит просроченные команды в timeout и забывает старые.
+// Вызывается под mu.
+func (d *Dispatcher) expire(now time.Time) {
+	for id, c := range d.cmds {
+		switch {
+		case c.Status == StatusSent && now.Sub(c.Created) > defaultTimeout:
+			c.Status, c.Updated = StatusTimeout, now
+		case c.Status != StatusSent && now.Sub(c.Updated) > keepFinished:
+			delete(d.cmds, id)
+		}
+	}
+}
+
+func (d *Dispatcher) mustGet(id string) Command {
+	c, _ := d.Get(id)
+	return c
+}
+
+// Get — состояние команды.
+func (d *Dispatcher) Get(id string) (Command, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(time.Now())
+	c, ok := d.cmds[id]
+	if !ok {
+		return Command{}, false
+	}
+	return *c, true
+}
+
+// List — команды устройства, от новых к старым.
+func (d *Dispatcher) List(deviceID string) []Command {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(time.Now())
+	out := []Command{}
+	for _, c := range d.cmds {
+		if c.DeviceID == deviceID {
+			out = append(out, *c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+	return out
+}
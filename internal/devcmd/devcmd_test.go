@@ -0,0 +1,51 @@
+package devcmd
+
+import (
+	"testing"
+	"time"
+
+	"sstmk-onvif/internal/registry"
+)
+
+type nopSender struct{}
+
+func (nopSender) Send(registry.Device, Command) error { return nil }
+
+// Завершённые команды забываются и тогда, когда список никто не читает.
+func TestSubmitExpiresOldCommands(t *testing.T) {
+	reg := registry.NewStore()
+	reg.Upsert(registry.Device{UID: "det-1"})
+	d := New(reg, nopSender{})
+
+	old, err := d.Submit("det-1", TypeReboot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := d.Submit("det-1", TypeReboot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Complete(old.ID, nil)
+
+	// сдвигаем время команд назад, не трогая часы
+	d.mu.Lock()
+	d.cmds[old.ID].Updated = time.Now().Add(-keepFinished - time.Minute)
+	d.cmds[stale.ID].Created = time.Now().Add(-defaultTimeout - time.Minute)
+	d.mu.Unlock()
+
+	if _, err := d.Submit("det-1", TypeReboot, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.cmds[old.ID]; ok {
+		t.Error("finished command older than keepFinished is kept")
+	}
+	if c := d.cmds[stale.ID]; c == nil || c.Status != StatusTimeout {
+		t.Errorf("unacknowledged command: %+v, want status timeout", c)
+	}
+	if len(d.cmds) != 2 {
+		t.Errorf("%d commands kept, want 2", len(d.cmds))
+	}
+}
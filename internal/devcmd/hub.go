@@ -0,0 +1,18 @@
+package devcmd
+
+import (
+	"sstmk-onvif/internal/hub"
+	"sstmk-onvif/internal/registry"
+)
+
+// HubSender ставит команду в очередь long-poll устройства
+// (POST /api/v1/devices/{id}/ping). Подтверждение — ID команды в поле
+// "ack" следующего ping.
+type HubSender struct {
+	Hub *hub.Hub
+}
+
+func (s HubSender) Send(d registry.Device, c Command) error {
+	s.Hub.Enqueue(d.UID, hub.Command{ID: c.ID, Type: c.Type, Payload: c.Payload})
+	return nil
+}
@@ -0,0 +1,249 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+
+	"sstmk-onvif/internal/config"
+	"sstmk-onvif/internal/registry"
+)
+
+const (
+	actionHello = "http://schemas.xmlsoap.org/ws/2005/04/discovery/Hello"
+	actionBye   = "http://schemas.xmlsoap.org/ws/2005/04/discovery/Bye"
+	// адресат Hello/Bye (WS-Discovery 2005/04, 4.1)
+	toDiscovery = "urn:schemas-xmlsoap-org:ws:2005:04:discovery"
+)
+
+var wsdGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 3702}
+
+// metadata — что устройство последний раз объявляло; смена XAddrs или
+// scope увеличивает version (MetadataVersion).
+type metadata struct {
+	version uint32
+	key     string
+}
+
+// Service — WS-Discovery: ответы на Probe/Resolve (UDP :3702 и HTTP) и
+// объявления Hello/Bye. Хранит общее для них состояние: AppSequence
+// и MetadataVersion устройств.
+type Service struct {
+	cfg *config.Config
+	reg *registry.Store
+
+	// InstanceId растёт между перезапусками: время старта
+	instanceID int64
+
+	mu        sync.Mutex
+	p         *ipv4.PacketConn // nil — UDP не слушаем, объявления не отправляются
+	ifaces    []net.Interface  // интерфейсы, где подключена multicast-группа
+	msgNumber uint64
+	meta      map[string]metadata
+
+	// announceMu — объявления по одному: слушатели реестра вызываются на
+	// горутинах изменивших его, и без блокировки быстрый online→offline
+	// мог отправить Bye раньше Hello. announced — устройства, для
+	// которых последним отправлен Hello (для Bye нужен их EndpointRef).
+	announceMu sync.Mutex
+	announced  map[string]registry.Device
+}
+
+func New(cfg *config.Config, reg *registry.Store) *Service {
+	return &Service{
+		cfg:        cfg,
+		reg:        reg,
+		instanceID: time.Now().Unix(),
+		meta:       map[string]metadata{},
+		announced:  map[string]registry.Device{},
+	}
+}
+
+// visible — устройство отвечает на Probe и объявляется через Hello.
+func visible(m registry.Device) bool {
+	return m.Enabled && m.Online
+}
+
+// appSequence — заголовок AppSequence со следующим MessageNumber.
+func (s *Service) appSequence() string {
+	s.mu.Lock()
+	s.msgNumber++
+	n := s.msgNumber
+	s.mu.Unlock()
+	return fmt.Sprintf(`<d:AppSequence InstanceId="%d" MessageNumber="%d"/>`, s.instanceID, n)
+}
+
+// metadataVersion — MetadataVersion устройства для ответов на Probe/Resolve
+// и Hello. Состояние не меняет: версию увеличивает только updateMetadata.
+func (s *Service) metadataVersion(uid string) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if md, ok := s.meta[uid]; ok {
+		return md.version
+	}
+	return 1
+}
+
+// updateMetadata запоминает XAddrs и scope устройства; changed — они
+// изменились с прошлого вызова (версия уже увеличена). Вызывается только
+// из announce, чтобы ответ на Probe не «съел» изменение до Hello. Адрес
+// хоста в сравнение не входит: он зависит от интерфейса запроса.
+func (s *Service) updateMetadata(m registry.Device) (changed bool) {
+	x, _ := s.cfg.XAddrs("", m)
+	key := x + "\n" + strings.Join(m.AllScopes(), " ")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	md, ok := s.meta[m.UID]
+	if !ok {
+		s.meta[m.UID] = metadata{version: 1, key: key}
+		return false
+	}
+	if md.key == key {
+		return false
+	}
+	md.version++
+	md.key = key
+	s.meta[m.UID] = md
+	return true
+}
+
+// send отправляет объявление на каждый интерфейс с multicast-группой; body
+// получает адрес, объявляемый на этом интерфейсе (см. interfaceHost).
+func (s *Service) send(action string, body func(host string) string) {
+	s.mu.Lock()
+	p, ifaces := s.p, s.ifaces
+	s.mu.Unlock()
+	if p == nil {
+		return
+	}
+	name := action[strings.LastIndex(action, "/")+1:]
+	if len(ifaces) == 0 {
+		// группа не подключена ни на одном интерфейсе — маршрут по умолчанию
+		host := s.cfg.PublicIP
+		if host == "" {
+			host = guessLocalIP(wsdGroup)
+		}
+		msg := announceEnvelope(action, s.appSequence(), body(host))
+		if _, err := p.WriteTo([]byte(msg), nil, wsdGroup); err != nil {
+			log.Printf("ws-discovery: send %s: %v", name, err)
+		}
+		return
+	}
+	for i := range ifaces {
+		ifi := &ifaces[i]
+		host := s.interfaceHost(ifi, nil)
+		if host == "" {
+			continue
+		}
+		msg := announceEnvelope(action, s.appSequence(), body(host))
+		if _, err := p.WriteTo([]byte(msg), &ipv4.ControlMessage{IfIndex: ifi.Index}, wsdGroup); err != nil {
+			log.Printf("ws-discovery: send %s on %s: %v", name, ifi.Name, err)
+		}
+	}
+}
+
+func (s *Service) hello(m registry.Device) {
+	version := s.metadataVersion(m.UID)
+	log.Printf("ws-discovery: Hello %s (MetadataVersion %d)", m.UID, version)
+	scopes := xmlEscape(strings.Join(m.AllScopes(), " "))
+	s.send(actionHello, func(host string) string {
+		x, _ := s.cfg.XAddrs(host, m)
+		return fmt.Sprintf(
+			`<d:Hello><a:EndpointReference><a:Address>%s</a:Address></a:EndpointReference><d:Types>dn:NetworkVideoTransmitter tds:Device</d:Types><d:Scopes>%s</d:Scopes><d:XAddrs>%s</d:XAddrs><d:MetadataVersion>%d</d:MetadataVersion></d:Hello>`,
+			m.EndpointRef, scopes, xmlEscape(x), version,
+		)
+	})
+}
+
+func (s *Service) bye(m registry.Device) {
+	log.Printf("ws-discovery: Bye %s", m.UID)
+	s.send(actionBye, func(string) string {
+		return fmt.Sprintf(
+			`<d:Bye><a:EndpointReference><a:Address>%s</a:Address></a:EndpointReference></d:Bye>`,
+			m.EndpointRef,
+		)
+	})
+}
+
+// onChange объявляет переходы видимости: Hello при появлении устройства
+// (включено и online) и при смене XAddrs/scope, Bye — при исчезновении.
+// Решение принимается по текущему состоянию реестра, а не по Change:
+// изменения одного устройства могут прийти сюда не в том порядке, в
+// котором их применил реестр.
+func (s *Service) onChange(c registry.Change) {
+	uid := c.Cur.UID
+	if c.Removed {
+		uid = c.Prev.UID
+	}
+	s.announceMu.Lock()
+	defer s.announceMu.Unlock()
+	s.announce(uid)
+}
+
+// announce приводит объявленное состояние устройства uid к состоянию
+// реестра. Вызывается под announceMu.
+func (s *Service) announce(uid string) {
+	m, ok := s.reg.Get(uid)
+	// версию отдают и ответы на Probe по HTTP, поэтому она ведётся до проверки UDP
+	changed := ok && s.updateMetadata(m)
+
+	s.mu.Lock()
+	listening := s.p != nil
+	s.mu.Unlock()
+	if !listening {
+		return
+	}
+
+	isVisible := ok && visible(m)
+	prev, wasVisible := s.announced[uid]
+	switch {
+	case isVisible && !wasVisible:
+		s.hello(m)
+	case wasVisible && !isVisible:
+		s.bye(prev)
+		delete(s.announced, uid)
+		return
+	case isVisible:
+		if changed {
+			s.hello(m)
+		}
+	default:
+		return
+	}
+	s.announced[uid] = m
+}
+
+// helloAll — объявление всех видимых устройств при старте.
+func (s *Service) helloAll() {
+	s.announceMu.Lock()
+	defer s.announceMu.Unlock()
+	for _, m := range s.reg.List() {
+		s.announce(m.UID)
+	}
+}
+
+// shutdown — Bye для всех объявленных устройств; после него объявления не отправляются.
+func (s *Service) shutdown() {
+	s.announceMu.Lock()
+	defer s.announceMu.Unlock()
+	for uid, m := range s.announced {
+		s.bye(m)
+		delete(s.announced, uid)
+	}
+	s.mu.Lock()
+	s.p = nil
+	s.mu.Unlock()
+}
+
+func announceEnvelope(action, appSequence, body string) string {
+	return fmt.Sprintf(
+		`<?xml version="1.0" encoding="UTF-8"?><e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" xmlns:a="%s" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl" xmlns:tds="http://www.onvif.org/ver10/device/wsdl"><e:Header><a:Action>%s</a:Action><a:MessageID>%s</a:MessageID><a:To>%s</a:To>%s</e:Header><e:Body>%s</e:Body></e:Envelope>`,
+		nsAddressing, action, uuidURN(), toDiscovery, appSequence, body,
+	)
+}
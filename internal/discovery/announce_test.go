@@ -0,0 +1,41 @@
+package discovery
+
+import (
+	"testing"
+
+	"sstmk-onvif/internal/config"
+	"sstmk-onvif/internal/registry"
+)
+
+// Ответ на Probe между изменением scope и обработкой изменения реестра
+// не должен увеличивать MetadataVersion: иначе announce не увидит
+// изменения и Hello с новыми scope не уйдёт.
+func TestMetadataVersionBumpedOnlyByAnnounce(t *testing.T) {
+	cfg := config.Defaults()
+	s := New(cfg, registry.NewStore())
+	m := registry.Device{UID: "det-1", Port: "9005", Enabled: true, Online: true}
+
+	if s.updateMetadata(m) {
+		t.Error("first updateMetadata: changed")
+	}
+	m.Scopes = []string{"onvif://www.onvif.org/location/hall"}
+
+	// Probe: только чтение
+	for range 3 {
+		if v := s.metadataVersion(m.UID); v != 1 {
+			t.Fatalf("metadataVersion = %d before announce, want 1", v)
+		}
+	}
+	if !s.updateMetadata(m) {
+		t.Fatal("updateMetadata after scope change: not changed")
+	}
+	if v := s.metadataVersion(m.UID); v != 2 {
+		t.Errorf("metadataVersion = %d, want 2", v)
+	}
+	if s.updateMetadata(m) {
+		t.Error("updateMetadata without changes: changed")
+	}
+	if v := s.metadataVersion("unknown"); v != 1 {
+		t.Errorf("metadataVersion of unknown device = %d, want 1", v)
+	}
+}
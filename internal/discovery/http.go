@@ -0,0 +1,50 @@
+package discovery
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ServeHTTP — направленный Probe/Resolve по HTTP (POST SOAP-конверта) для VMS
+// из других подсетей, куда не доходит multicast. Ответ — ProbeMatches или
+// ResolveMatches, пустой, если ничего не подошло.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req, err := parseRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// XAddrs: advertised_hosts интерфейса, принявшего соединение, public_ip
+	// или адрес, по которому клиент достучался до шлюза
+	host := s.cfg.PublicIP
+	if la, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr); ok {
+		if ifi := interfaceByIP(la.IP); ifi != nil && s.cfg.AdvertisedHosts[ifi.Name] != "" {
+			host = s.cfg.AdvertisedHosts[ifi.Name]
+		}
+	}
+	if host == "" {
+		host = r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+	}
+
+	matches := s.answer(req, host)
+	log.Printf("ws-discovery: HTTP %s from %s: %d match(es)", req.Kind, r.RemoteAddr, len(matches))
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	_, _ = io.WriteString(w, s.responseEnvelope(req, strings.Join(matches, "")))
+}
@@ -0,0 +1,84 @@
+package discovery
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// hostFor — IP для XAddrs в ответе на UDP-запрос от raddr, по интерфейсу
+// прихода из cm: advertised_hosts[интерфейс] → public_ip → адрес назначения
+// направленного запроса → IPv4 интерфейса (из подсети запросившего) → guessLocalIP.
+func (s *Service) hostFor(cm *ipv4.ControlMessage, raddr net.Addr) string {
+	var ifi *net.Interface
+	if cm != nil && cm.IfIndex > 0 {
+		ifi, _ = net.InterfaceByIndex(cm.IfIndex)
+	}
+	if ifi != nil {
+		if h := s.cfg.AdvertisedHosts[ifi.Name]; h != "" {
+			return h
+		}
+	}
+	if s.cfg.PublicIP != "" {
+		return s.cfg.PublicIP
+	}
+	// unicast-запрос пришёл на наш адрес — он и достижим для клиента
+	if cm != nil && cm.Dst != nil && !cm.Dst.IsMulticast() && !cm.Dst.Equal(net.IPv4bcast) {
+		return cm.Dst.String()
+	}
+	if ifi != nil {
+		var peer net.IP
+		if ua, ok := raddr.(*net.UDPAddr); ok {
+			peer = ua.IP
+		}
+		if h := ifaceIPv4(ifi, peer); h != "" {
+			return h
+		}
+	}
+	return guessLocalIP(raddr)
+}
+
+// interfaceHost — адрес, объявляемый на интерфейсе ifi: advertised_hosts,
+// public_ip или IPv4 интерфейса (из подсети peer, если их несколько).
+// Пусто — у интерфейса нет IPv4.
+func (s *Service) interfaceHost(ifi *net.Interface, peer net.IP) string {
+	if h := s.cfg.AdvertisedHosts[ifi.Name]; h != "" {
+		return h
+	}
+	if s.cfg.PublicIP != "" {
+		return s.cfg.PublicIP
+	}
+	return ifaceIPv4(ifi, peer)
+}
+
+func ifaceIPv4(ifi *net.Interface, peer net.IP) string {
+	addrs, _ := ifi.Addrs()
+	first := ""
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil {
+			continue
+		}
+		if peer != nil && ipnet.Contains(peer) {
+			return ipnet.IP.String()
+		}
+		if first == "" {
+			first = ipnet.IP.String()
+		}
+	}
+	return first
+}
+
+// interfaceByIP — интерфейс, которому принадлежит ip.
+func interfaceByIP(ip net.IP) *net.Interface {
+	ifaces, _ := net.Interfaces()
+	for i := range ifaces {
+		addrs, _ := ifaces[i].Addrs()
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return &ifaces[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"sstmk-onvif/internal/registry"
+)
+
+const (
+	nsDiscovery  = "http://schemas.xmlsoap.org/ws/2005/04/discovery"
+	nsAddressing = "http://schemas.xmlsoap.org/ws/2004/08/addressing" // WS-Discovery 2005/04 — WS-Addressing 2004/08
+	nsNetwork    = "http://www.onvif.org/ver10/network/wsdl"
+	nsDevice     = "http://www.onvif.org/ver10/device/wsdl"
+	matchRFC3986 = nsDiscovery + "/rfc3986"
+	matchStrcmp0 = nsDiscovery + "/strcmp0"
+)
+
+var errNotRequest = errors.New("not a Probe or Resolve")
+
+// Виды запросов
+const (
+	kindProbe   = "Probe"
+	kindResolve = "Resolve"
+)
+
+// deviceTypes — типы, которые объявляет каждое устройство
+// (dn:NetworkVideoTransmitter tds:Device).
+var deviceTypes = []xml.Name{
+	{Space: nsNetwork, Local: "NetworkVideoTransmitter"},
+	{Space: nsDevice, Local: "Device"},
+}
+
+// request — разобранный запрос Probe или Resolve.
+type request struct {
+	Kind      string
+	MessageID string
+
+	// Probe
+	Types   []xml.Name // QName с раскрытыми префиксами
+	Scopes  []string
+	MatchBy string
+
+	// Resolve: адрес EndpointReference
+	Address string
+}
+
+// parseRequest разбирает SOAP-конверт Probe или Resolve. Префиксы QName
+// в Types раскрываются по объявлениям xmlns, действующим на элементе.
+func parseRequest(data []byte) (request, error) {
+	var (
+		p       request
+		found   bool
+		path    []string            // локальные имена открытых элементов
+		nsStack []map[string]string // объявления префиксов по уровням
+		text    strings.Builder
+	)
+	resolve := func(prefix string) string {
+		for i := len(nsStack) - 1; i >= 0; i-- {
+			if ns, ok := nsStack[i][prefix]; ok {
+				return ns
+			}
+		}
+		return ""
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.RawToken()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return request{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			decl := map[string]string{}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					decl[a.Name.Local] = a.Value
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					decl[""] = a.Value
+				}
+			}
+			nsStack = append(nsStack, decl)
+			path = append(path, t.Name.Local)
+			text.Reset()
+
+			if (t.Name.Local == kindProbe || t.Name.Local == kindResolve) && len(path) == 3 && path[1] == "Body" && resolve(t.Name.Space) == nsDiscovery {
+				found = true
+				p.Kind = t.Name.Local
+			}
+			if found && len(path) == 4 && path[2] == "Probe" && t.Name.Local == "Scopes" {
+				for _, a := range t.Attr {
+					if a.Name.Local == "MatchBy" {
+						p.MatchBy = strings.TrimSpace(a.Value)
+					}
+				}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(path) == 0 {
+				return request{}, errors.New("unbalanced XML")
+			}
+			v := strings.TrimSpace(text.String())
+			switch {
+			case len(path) == 3 && path[1] == "Header" && t.Name.Local == "MessageID":
+				p.MessageID = v
+			case len(path) == 4 && found && path[2] == "Probe" && t.Name.Local == "Types":
+				for _, q := range strings.Fields(v) {
+					prefix, local, ok := strings.Cut(q, ":")
+					if !ok {
+						prefix, local = "", q
+					}
+					p.Types = append(p.Types, xml.Name{Space: resolve(prefix), Local: local})
+				}
+			case len(path) == 4 && found && path[2] == "Probe" && t.Name.Local == "Scopes":
+				p.Scopes = strings.Fields(v)
+			case len(path) == 5 && found && path[2] == "Resolve" && path[3] == "EndpointReference" && t.Name.Local == "Address":
+				p.Address = v
+			}
+			path = path[:len(path)-1]
+			nsStack = nsStack[:len(nsStack)-1]
+			text.Reset()
+		}
+	}
+	if len(path) != 0 {
+		// RawToken не проверяет, что элементы закрыты: обрезанная датаграмма
+		return request{}, errors.New("unbalanced XML")
+	}
+	if !found {
+		return request{}, errNotRequest
+	}
+	return p, nil
+}
+
+// matches — устройство подходит под запрос: для Probe — под все Types
+// и Scopes, для Resolve — по адресу EndpointReference.
+func (p request) matches(m registry.Device) bool {
+	if p.Kind == kindResolve {
+		return p.Address != "" && strings.EqualFold(p.Address, m.EndpointRef)
+	}
+	for _, t := range p.Types {
+		ok := false
+		for _, dt := range deviceTypes {
+			if t == dt {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	scopes := m.AllScopes()
+	for _, want := range p.Scopes {
+		ok := false
+		for _, have := range scopes {
+			if scopeMatch(p.MatchBy, want, have) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// scopeMatch — scope запроса want подходит к scope устройства have.
+// Неизвестное правило MatchBy не совпадает ни с чем.
+func scopeMatch(matchBy, want, have string) bool {
+	switch matchBy {
+	case "", matchRFC3986:
+		return rfc3986Match(want, have)
+	case matchStrcmp0:
+		return want == have
+	}
+	return false
+}
+
+// rfc3986Match — правило WS-Discovery rfc3986: схема и authority совпадают
+// без учёта регистра, путь want — посегментный префикс пути have
+// (сегменты сравниваются после раскрытия %-кодирования). Сегменты "." и ".."
+// не допускаются.
+func rfc3986Match(want, have string) bool {
+	w, err := url.Parse(want)
+	if err != nil {
+		return false
+	}
+	h, err := url.Parse(have)
+	if err != nil {
+		return false
+	}
+	if !strings.EqualFold(w.Scheme, h.Scheme) || !strings.EqualFold(w.Host, h.Host) || w.User.String() != h.User.String() {
+		return false
+	}
+	ws, hs := pathSegments(w), pathSegments(h)
+	if ws == nil || hs == nil || len(ws) > len(hs) {
+		return false
+	}
+	for i := range ws {
+		if ws[i] != hs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// pathSegments — непустые раскрытые сегменты пути; nil при "." или ".."
+// и неверном %-кодировании.
+func pathSegments(u *url.URL) []string {
+	out := []string{}
+	for _, s := range strings.Split(u.EscapedPath(), "/") {
+		seg, err := url.PathUnescape(s)
+		if err != nil {
+			return nil
+		}
+		switch seg {
+		case "":
+			continue
+		case ".", "..":
+			return nil
+		}
+		out = append(out, seg)
+	}
+	return out
+}
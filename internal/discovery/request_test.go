@@ -0,0 +1,188 @@
+package discovery
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRFC3986Match(t *testing.T) {
+	const have = "onvif://www.onvif.org/location/city/Moscow%20North"
+	tests := []struct {
+		want, have string
+		match      bool
+	}{
+		{"onvif://www.onvif.org/location", have, true},
+		{"onvif://www.onvif.org/location/city", have, true},
+		{"onvif://www.onvif.org/location/city/", have, true},
+		{"onvif://www.onvif.org/location/city/Moscow%20North", have, true},
+		{"onvif://www.onvif.org/location/city/Moscow North", have, true},
+		{"ONVIF://WWW.ONVIF.ORG/location", have, true}, // схема и authority без учёта регистра
+		{"onvif://www.onvif.org", have, true},
+		{"onvif://www.onvif.org/location/%63ity", have, true}, // %63 = c
+
+		{"onvif://www.onvif.org/Location", have, false}, // путь с учётом регистра
+		{"onvif://www.onvif.org/loc", have, false},      // только целые сегменты
+		{"onvif://www.onvif.org/location/city/Moscow", have, false},
+		{"onvif://www.onvif.org/location/city/Moscow%20North/x", have, false},
+		{"http://www.onvif.org/location", have, false},
+		{"onvif://onvif.org/location", have, false},
+		{"onvif://user@www.onvif.org/location", have, false},
+
+		// неверное %-кодирование и точечные сегменты не совпадают ни с чем
+		{"onvif://www.onvif.org/location/%zz", have, false},
+		{"onvif://www.onvif.org/location/%2", have, false},
+		{"onvif://www.onvif.org/location", "onvif://www.onvif.org/location/%zz", false},
+		{"onvif://www.onvif.org/location/./city", have, false},
+		{"onvif://www.onvif.org/location/../location", have, false},
+		{"onvif://www.onvif.org/location", "onvif://www.onvif.org/location/city/..", false},
+	}
+	for _, tt := range tests {
+		if got := rfc3986Match(tt.want, tt.have); got != tt.match {
+			t.Errorf("rfc3986Match(%q, %q) = %v, want %v", tt.want, tt.have, got, tt.match)
+		}
+	}
+}
+
+func TestPathSegments(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"onvif://www.onvif.org", []string{}},
+		{"onvif://www.onvif.org/", []string{}},
+		{"onvif://www.onvif.org/a/b", []string{"a", "b"}},
+		{"onvif://www.onvif.org//a///b/", []string{"a", "b"}},
+		{"onvif://www.onvif.org/a%2Fb/c", []string{"a/b", "c"}}, // %2F — часть сегмента
+		{"onvif://www.onvif.org/name/%D0%A1%D0%A1%D0%A2%D0%9C%D0%9A", []string{"name", "ССТМК"}},
+		{"onvif://www.onvif.org/a/./b", nil},
+		{"onvif://www.onvif.org/a/..", nil},
+		{"onvif://www.onvif.org/%2E%2E/a", nil},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.in)
+		if err != nil {
+			t.Fatalf("url.Parse(%q): %v", tt.in, err)
+		}
+		if got := pathSegments(u); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pathSegments(%q) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestScopeMatch(t *testing.T) {
+	const have = "onvif://www.onvif.org/type/video_encoder"
+	tests := []struct {
+		matchBy, want string
+		match         bool
+	}{
+		{"", "onvif://www.onvif.org/type", true},
+		{matchRFC3986, "onvif://www.onvif.org/type", true},
+		{matchStrcmp0, "onvif://www.onvif.org/type", false},
+		{matchStrcmp0, have, true},
+		{matchStrcmp0, "ONVIF://www.onvif.org/type/video_encoder", false},
+		{"http://example.com/unknown", have, false},
+	}
+	for _, tt := range tests {
+		if got := scopeMatch(tt.matchBy, tt.want, have); got != tt.match {
+			t.Errorf("scopeMatch(%q, %q) = %v, want %v", tt.matchBy, tt.want, got, tt.match)
+		}
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	const head = `<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">` +
+		`<e:Header><a:MessageID>uuid:1</a:MessageID></e:Header><e:Body>`
+	const tail = `</e:Body></e:Envelope>`
+
+	tests := []struct {
+		name    string
+		body    string
+		want    request
+		wantErr error
+	}{
+		{
+			name: "probe with types and scopes",
+			body: `<d:Probe><d:Types xmlns:dn="http://www.onvif.org/ver10/network/wsdl">dn:NetworkVideoTransmitter</d:Types>` +
+				`<d:Scopes MatchBy="http://schemas.xmlsoap.org/ws/2005/04/discovery/strcmp0"> onvif://www.onvif.org/name/a  onvif://www.onvif.org/type </d:Scopes></d:Probe>`,
+			want: request{
+				Kind:      kindProbe,
+				MessageID: "uuid:1",
+				Types:     []xml.Name{{Space: nsNetwork, Local: "NetworkVideoTransmitter"}},
+				Scopes:    []string{"onvif://www.onvif.org/name/a", "onvif://www.onvif.org/type"},
+				MatchBy:   matchStrcmp0,
+			},
+		},
+		{
+			name: "empty probe",
+			body: `<d:Probe/>`,
+			want: request{Kind: kindProbe, MessageID: "uuid:1"},
+		},
+		{
+			name: "prefix redeclared on Types",
+			body: `<d:Probe xmlns:x="urn:outer"><d:Types xmlns:x="http://www.onvif.org/ver10/device/wsdl">x:Device</d:Types></d:Probe>`,
+			want: request{
+				Kind:      kindProbe,
+				MessageID: "uuid:1",
+				Types:     []xml.Name{{Space: nsDevice, Local: "Device"}},
+			},
+		},
+		{
+			name: "undeclared prefix",
+			body: `<d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe>`,
+			want: request{
+				Kind:      kindProbe,
+				MessageID: "uuid:1",
+				Types:     []xml.Name{{Local: "NetworkVideoTransmitter"}},
+			},
+		},
+		{
+			name: "resolve",
+			body: `<d:Resolve><a:EndpointReference><a:Address> urn:uuid:0f5d6a3c-0000-5000-8000-000000000001 </a:Address></a:EndpointReference></d:Resolve>`,
+			want: request{
+				Kind:      kindResolve,
+				MessageID: "uuid:1",
+				Address:   "urn:uuid:0f5d6a3c-0000-5000-8000-000000000001",
+			},
+		},
+		{
+			name:    "other namespace",
+			body:    `<x:Probe xmlns:x="urn:other"/>`,
+			wantErr: errNotRequest,
+		},
+		{
+			name:    "other operation",
+			body:    `<d:Hello/>`,
+			wantErr: errNotRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRequest([]byte(head + tt.body + tail))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseRequest: %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Датаграмма, обрезанная после Probe, не должна приниматься за
+// пустой Probe: RawToken не проверяет, что элементы закрыты.
+func TestParseRequestTruncated(t *testing.T) {
+	const head = `<e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">` +
+		`<e:Body>`
+	for _, raw := range []string{
+		head + `<d:Probe>`,
+		head + `<d:Probe/>`,
+		head + `<d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></e:Body>`,
+	} {
+		if got, err := parseRequest([]byte(raw)); err == nil {
+			t.Errorf("parseRequest(%q) = %+v, want error", raw, got)
+		}
+	}
+}
@@ -10,17 +10,13 @@ import (
 	"time"
 
 	"golang.org/x/net/ipv4"
-
-	"sstmk-onvif/internal/config"
-	"sstmk-onvif/internal/registry"
 )
 
-func Start(ctx context.Context, cfg *config.Config, reg *registry.Store) error {
-	go runWSDiscovery(ctx, cfg, reg)
-	return nil
-}
-
-func runWSDiscovery(ctx context.Context, cfg *config.Config, reg *registry.Store) {
+// Run слушает UDP :3702 (multicast и направленные unicast-запросы) и объявляет
+// устройства (Hello/Bye) до отмены ctx; перед выходом отправляет Bye для всех
+// видимых устройств.
+func (s *Service) Run(ctx context.Context) {
+	cfg := s.cfg
 	log.Printf("ws-discovery: starting on UDP :3702")
 	pc, err := net.ListenPacket("udp4", "0.0.0.0:3702")
 	if err != nil {
@@ -31,9 +27,11 @@ func runWSDiscovery(ctx context.Context, cfg *config.Config, reg *registry.Store
 	log.Printf("ws-discovery: successfully listening on UDP :3702")
 
 	p := ipv4.NewPacketConn(pc)
-	_ = p.SetControlMessage(ipv4.FlagDst, true)
+	// адрес назначения и интерфейс прихода — для выбора XAddrs (hostFor)
+	_ = p.SetControlMessage(ipv4.FlagDst|ipv4.FlagInterface, true)
 	_ = p.SetMulticastLoopback(true)
 
+	var joined []net.Interface // Hello/Bye уходят на каждый из них
 	if cfg.LANIfName != "" {
 		if ifi, err := net.InterfaceByName(cfg.LANIfName); err != nil {
 			log.Printf("ws-discovery: cannot find iface %s: %v", cfg.LANIfName, err)
@@ -43,6 +41,7 @@ func runWSDiscovery(ctx context.Context, cfg *config.Config, reg *registry.Store
 				log.Printf("ws-discovery: JoinGroup on %s failed: %v", cfg.LANIfName, err)
 			} else {
 				log.Printf("ws-discovery: joined %s on %s", mcast.String(), cfg.LANIfName)
+				joined = append(joined, *ifi)
 			}
 			_ = p.SetMulticastInterface(ifi)
 			_ = p.SetMulticastTTL(1)
@@ -59,11 +58,19 @@ func runWSDiscovery(ctx context.Context, cfg *config.Config, reg *registry.Store
 				log.Printf("ws-discovery: JoinGroup on %s failed: %v", ifc.Name, err)
 			} else {
 				log.Printf("ws-discovery: joined %s on %s", mcast.String(), ifc.Name)
+				joined = append(joined, ifc)
 			}
 		}
 		_ = p.SetMulticastTTL(1)
 	}
 
+	s.mu.Lock()
+	s.p = p
+	s.ifaces = joined
+	s.mu.Unlock()
+	s.reg.OnChange(s.onChange)
+	s.helloAll()
+
 	buf := make([]byte, 8192)
 	const maxUDP = 1300
 
@@ -72,6 +79,7 @@ func runWSDiscovery(ctx context.Context, cfg *config.Config, reg *registry.Store
 		// cancellation?
 		if err := ctx.Err(); err != nil {
 			log.Printf("ws-discovery: context cancelled, stopping")
+			s.shutdown()
 			return
 		}
 
@@ -85,130 +93,107 @@ func runWSDiscovery(ctx context.Context, cfg *config.Config, reg *registry.Store
 			continue
 		}
 
-		_ = cm // destination info не используем здесь
-
-		req := string(buf[:n])
-
 		log.Printf("ws-discovery: received %d bytes from %s", n, raddr)
 
-		log.Printf("DATA=%s", req)
-		if !looksLikeProbe(req) {
-			log.Printf("Не прошла проверку")
+		req, err := parseRequest(buf[:n])
+		if err != nil {
+			log.Printf("Не прошла проверку: %v", err)
 			continue
 		}
+		// направленный запрос (unicast на :3702) получает ответ и без совпадений
+		directed := cm != nil && cm.Dst != nil && !cm.Dst.IsMulticast()
 
-		relates := extractTag(req, "MessageID")
-		if relates == "" {
-			relates = uuidURN()
-		}
-
-		// IP в XAddr: public_ip (если задан) или автоопределение
-		localIP := cfg.PublicIP
-		if localIP == "" {
-			localIP = guessLocalIP(raddr)
-		}
+		// IP в XAddr: по интерфейсу, через который пришёл запрос
+		localIP := s.hostFor(cm, raddr)
 
 		// соберём матчи
 		var chunkBody strings.Builder
+		sent := false
 		sendChunk := func() {
-			if chunkBody.Len() == 0 {
+			if chunkBody.Len() == 0 && (sent || !directed) {
 				log.Printf("Нет устройств для отправки")
 				return
 			}
-			resp := envelope(relates, chunkBody.String())
-			log.Printf("Отправляем ответ с %d устройствами на %s", strings.Count(chunkBody.String(), "ProbeMatch"), raddr)
+			resp := s.responseEnvelope(req, chunkBody.String())
+			log.Printf("Отправляем %sMatches на %s", req.Kind, raddr)
 			n, err := p.WriteTo([]byte(resp), nil, raddr)
 			if err != nil {
 				log.Printf("Ошибка отправки: %v", err)
 			} else {
 				log.Printf("Отправлено %d байт", n)
 			}
+			sent = true
 			chunkBody.Reset()
 			time.Sleep(10 * time.Millisecond)
 		}
-		log.Println("List=", reg.List())
-		// Выводим registry в JSON формате для отладки
-		reg.RegisterOrUpdate()
-		log.Printf("Начинаем обработку %d устройств", len(reg.List()))
-		for _, m := range reg.List() {
-			log.Printf("Проверяем устройство %s: enabled=%t, online=%t, name=%s", m.UID, m.Enabled, m.Online, m.Name)
-			// ⬇️ НЕ показываем устройство, если оно выключено или оффлайн
-			if !m.Enabled || !m.Online {
-				log.Printf("Пропускаем устройство %s (не активно)", m.UID)
-				continue
-			}
-
-			x := fmt.Sprintf("http://%s:%s%s", localIP, m.Port, cfg.DevicePath)
-			log.Printf("Добавляем устройство %s с XAddr: %s", m.UID, x)
-			scopes := fmt.Sprintf(
-				"onvif://www.onvif.org/name/%s onvif://www.onvif.org/type/%s",
-				m.Name, m.Model,
-			)
-			log.Printf("Scopes для %s: %s", m.UID, scopes)
-
-			one := matchXML(probeMatch{
-				Endpoint: uuidURN(), // можно сделать стабильным, если захочешь (см. ниже)
-				XAddr:    x,
-				Scopes:   scopes,
-			})
-			log.Printf("XML для %s: %s", m.UID, one)
-
+		for _, one := range s.answer(req, localIP) {
 			if chunkBody.Len() > 0 && (chunkBody.Len()+len(one) > maxUDP) {
 				sendChunk()
 			}
 			chunkBody.WriteString(one)
 		}
-		log.Printf("%v", reg.List())
 		sendChunk()
 	}
 }
 
+// answer — ProbeMatch/ResolveMatch видимых устройств, подходящих под запрос;
+// host — IP для XAddrs.
+func (s *Service) answer(req request, host string) []string {
+	if req.MatchBy != "" && req.MatchBy != matchRFC3986 && req.MatchBy != matchStrcmp0 {
+		log.Printf("ws-discovery: unsupported MatchBy %q", req.MatchBy)
+	}
+	var out []string
+	for _, m := range s.reg.List() {
+		// ⬇️ НЕ показываем устройство, если оно выключено или оффлайн
+		if !visible(m) || !req.matches(m) {
+			continue
+		}
+		x, _ := s.cfg.XAddrs(host, m)
+		version := s.metadataVersion(m.UID)
+		log.Printf("Добавляем устройство %s с XAddr: %s", m.UID, x)
+		out = append(out, matchXML(req.Kind, probeMatch{
+			Endpoint:        m.EndpointRef,
+			XAddr:           x,
+			Scopes:          strings.Join(m.AllScopes(), " "),
+			MetadataVersion: version,
+		}))
+	}
+	return out
+}
+
+// responseEnvelope — ProbeMatches/ResolveMatches с фрагментами body.
+func (s *Service) responseEnvelope(req request, body string) string {
+	relates := req.MessageID
+	if relates == "" {
+		relates = uuidURN()
+	}
+	return envelope(req.Kind+"Matches", relates, s.appSequence(), body)
+}
+
 /* ---------- helpers (локальные, чтобы не плодить зависимостей) ---------- */
 
 type probeMatch struct {
 	Endpoint, XAddr, Scopes string
+	MetadataVersion         uint32
 }
 
-func matchXML(m probeMatch) string {
+// matchXML — ProbeMatch (kind=Probe) или ResolveMatch (kind=Resolve).
+func matchXML(kind string, m probeMatch) string {
 	return fmt.Sprintf(
-		`<d:ProbeMatch><a:EndpointReference><a:Address>%s</a:Address></a:EndpointReference><d:Types>dn:NetworkVideoTransmitter</d:Types><d:Scopes>%s</d:Scopes><d:XAddrs>%s</d:XAddrs><d:MetadataVersion>1</d:MetadataVersion></d:ProbeMatch>`,
-		m.Endpoint, xmlEscape(m.Scopes), xmlEscape(m.XAddr),
+		`<d:%[1]sMatch><a:EndpointReference><a:Address>%s</a:Address></a:EndpointReference><d:Types>dn:NetworkVideoTransmitter tds:Device</d:Types><d:Scopes>%s</d:Scopes><d:XAddrs>%s</d:XAddrs><d:MetadataVersion>%[5]d</d:MetadataVersion></d:%[1]sMatch>`,
+		kind, m.Endpoint, xmlEscape(m.Scopes), xmlEscape(m.XAddr), m.MetadataVersion,
 	)
 }
 
 // Опрос устройств onvif
 // TODO: Переделать на xml/go
-func envelope(relatesTo, body string) string {
+func envelope(action, relatesTo, appSequence, body string) string {
 	return fmt.Sprintf(
-		`<?xml version="1.0" encoding="UTF-8"?><e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:a="http://www.w3.org/2005/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl"><e:Header><a:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches</a:Action><a:MessageID>%s</a:MessageID><a:RelatesTo>%s</a:RelatesTo><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To></e:Header><e:Body><d:ProbeMatches>%s</d:ProbeMatches></e:Body></e:Envelope>`,
-		uuidURN(), relatesTo, body,
+		`<?xml version="1.0" encoding="UTF-8"?><e:Envelope xmlns:e="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:a="%[6]s" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl" xmlns:tds="http://www.onvif.org/ver10/device/wsdl"><e:Header><a:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/%[1]s</a:Action><a:MessageID>%[2]s</a:MessageID><a:RelatesTo>%[3]s</a:RelatesTo><a:To>%[6]s/role/anonymous</a:To>%[4]s</e:Header><e:Body><d:%[1]s>%[5]s</d:%[1]s></e:Body></e:Envelope>`,
+		action, uuidURN(), relatesTo, appSequence, body, nsAddressing,
 	)
 }
 
-// Проверка формата пакета
-func looksLikeProbe(xml string) bool {
-	low := strings.ToLower(xml)
-	return strings.Contains(low, "/discovery/probe") || strings.Contains(xml, "<Probe") || strings.Contains(xml, ":Probe")
-}
-
-func extractTag(xml, tag string) string {
-	open1, close1 := "<a:"+tag+">", "</a:"+tag+">"
-	if i := strings.Index(xml, open1); i >= 0 {
-		i += len(open1)
-		if j := strings.Index(xml[i:], close1); j >= 0 {
-			return xml[i : i+j]
-		}
-	}
-	open2, close2 := "<"+tag+">", "</"+tag+">"
-	if i := strings.Index(xml, open2); i >= 0 {
-		i += len(open2)
-		if j := strings.Index(xml[i:], close2); j >= 0 {
-			return xml[i : i+j]
-		}
-	}
-	return ""
-}
-
 func xmlEscape(s string) string {
 	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", `'`, "&apos;").Replace(s)
 }
@@ -223,7 +208,7 @@ func uuidURN() string {
 
 func guessLocalIP(raddr net.Addr) string {
 	ra, _ := net.ResolveUDPAddr("udp4", raddr.String())
-	conn, err := net.Dial("udp4", fmt.Sprintf("%s:%d", ra.IP.String(), ra.Port))
+	conn, err := net.Dial("udp4", ra.String())
 	if err == nil {
 		defer conn.Close()
 		if la, ok := conn.LocalAddr().(*net.UDPAddr); ok {
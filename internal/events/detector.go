@@ -0,0 +1,37 @@
+package events
+
+// TopicDetector — топик события прохода через детектор металла.
+const TopicDetector = "detector/event"
+
+// DetectorPayload — payload события TopicDetector (JSON).
+type DetectorPayload struct {
+	Data     any           `json:"data"`     // исходный пакет детектора (для веб-интерфейса и логов)
+	Image    string        `json:"image"`    // картинка зон, PNG в base64
+	Detector DetectorEvent `json:"detector"` // типизированные данные события
+}
+
+// DetectorEvent — событие детектора, независимое от бинарного протокола.
+type DetectorEvent struct {
+	Classification Classification `json:"classification"`
+	In             uint32         `json:"in"`
+	Out            uint32         `json:"out"`
+	Inside         uint32         `json:"inside"`
+	AlarmsIn       uint32         `json:"alarms_in"`  // тревоги по металлу на вход
+	AlarmsOut      uint32         `json:"alarms_out"` // тревоги по металлу на выход
+	// Zones — сетка зон: строки по уровням катушек снизу вверх,
+	// в строке — стороны детектора.
+	Zones [][]Zone `json:"zones"`
+}
+
+// Classification — результат классификации обнаруженного объекта.
+type Classification struct {
+	Type   uint32 `json:"type"`
+	Class  uint32 `json:"class"`
+	Object uint32 `json:"object"`
+}
+
+// Zone — показания одной зоны детектора.
+type Zone struct {
+	Level uint8  `json:"level"`
+	Cnt   uint32 `json:"cnt"`
+}
@@ -0,0 +1,11 @@
+package events
+
+// TopicInput — топик смены состояния цифрового входа МК (TTY EVT,<id>,<state>).
+const TopicInput = "input"
+
+// InputPayload — payload события TopicInput (JSON).
+type InputPayload struct {
+	Input int    `json:"input"`           // номер входа в протоколе TTY
+	State int    `json:"state"`           // 1 — активен, 0 — норма
+	Token string `json:"token,omitempty"` // токен входа ONVIF, если вход привязан к устройству
+}
@@ -0,0 +1,9 @@
+package events
+
+// TopicOnline — топик перехода устройства online/offline (изменение реестра).
+const TopicOnline = "system/online"
+
+// OnlinePayload — payload события TopicOnline (JSON).
+type OnlinePayload struct {
+	Online bool `json:"online"`
+}
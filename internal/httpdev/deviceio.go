@@ -0,0 +1,155 @@
+package httpdev
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"sstmk-onvif/internal/config"
+	"sstmk-onvif/internal/soap"
+)
+
+/* ---------- DeviceIO ----------
+
+Входы и реле МК на линии TTY (config tty.inputs / tty.relays),
+привязанные к устройству. Операции обслуживаются на адресе Device
+Service; GetRelayOutputs/SetRelayOutputState есть и в пространстве
+имён tds, и в tmd — ответ в том же пространстве, что и запрос.
+*/
+
+const nsDeviceIO = "http://www.onvif.org/ver10/deviceIO/wsdl"
+
+// RelayDriver переключает реле МК по номеру из протокола TTY.
+type RelayDriver interface {
+	SetRelay(id int, active bool) error
+}
+
+// deviceIO — входы и реле устройства uid из конфига.
+func deviceIO(cfg *config.Config, uid string) (inputs, relays []config.TTYIOConfig) {
+	for _, in := range cfg.TTY.Inputs {
+		if in.Device == uid {
+			inputs = append(inputs, in)
+		}
+	}
+	for _, out := range cfg.TTY.Relays {
+		if out.Device == uid {
+			relays = append(relays, out)
+		}
+	}
+	return inputs, relays
+}
+
+// ioPrefix — префикс ответа по пространству имён запроса.
+func ioPrefix(env *soap.Envelope) (prefix, ns string) {
+	if env.Operation.Space == nsDeviceIO {
+		return "tmd", nsDeviceIO
+	}
+	return "tds", nsDevice
+}
+
+func soapResponseGetDigitalInputs(inputs []config.TTYIOConfig) string {
+	var b strings.Builder
+	b.WriteString(`
+<tmd:GetDigitalInputsResponse xmlns:tmd="http://www.onvif.org/ver10/deviceIO/wsdl">`)
+	for _, in := range inputs {
+		fmt.Fprintf(&b, `
+  <tmd:DigitalInputs token="%s" IdleState="open"/>`, xmlText(in.Token))
+	}
+	b.WriteString(`
+</tmd:GetDigitalInputsResponse>`)
+	return soapEnvelope(b.String())
+}
+
+func soapResponseGetRelayOutputs(prefix, ns string, relays []config.TTYIOConfig) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `
+<%s:GetRelayOutputsResponse xmlns:%s="%s" xmlns:tt="http://www.onvif.org/ver10/schema">`, prefix, prefix, ns)
+	for _, out := range relays {
+		fmt.Fprintf(&b, `
+  <%s:RelayOutputs token="%s">
+    <tt:Properties><tt:Mode>Bistable</tt:Mode><tt:DelayTime>PT0S</tt:DelayTime><tt:IdleState>open</tt:IdleState></tt:Properties>
+  </%s:RelayOutputs>`, prefix, xmlText(out.Token), prefix)
+	}
+	fmt.Fprintf(&b, `
+</%s:GetRelayOutputsResponse>`, prefix)
+	return soapEnvelope(b.String())
+}
+
+func soapResponseGetDeviceIOServiceCapabilities(inputs, relays int) string {
+	b := fmt.Sprintf(`
+<tmd:GetServiceCapabilitiesResponse xmlns:tmd="http://www.onvif.org/ver10/deviceIO/wsdl">
+  <tmd:Capabilities VideoSources="0" VideoOutputs="0" AudioSources="0" AudioOutputs="0"
+                    RelayOutputs="%d" SerialPorts="0" DigitalInputs="%d" DigitalInputOptions="false"/>
+</tmd:GetServiceCapabilitiesResponse>`, relays, inputs)
+	return soapEnvelope(b)
+}
+
+type setRelayOutputStateRequest struct {
+	RelayOutputToken string `xml:"RelayOutputToken"`
+	LogicalState     string `xml:"LogicalState"` // active | inactive
+}
+
+func handleSetRelayOutputState(w http.ResponseWriter, r *http.Request, env *soap.Envelope, cfg *config.Config, relays RelayDriver) {
+	var req setRelayOutputStateRequest
+	if err := env.DecodeBody(&req); err != nil {
+		soap.WriteFault(w, env, soap.InvalidArgs(err.Error()))
+		return
+	}
+
+	var active bool
+	switch strings.TrimSpace(req.LogicalState) {
+	case "active":
+		active = true
+	case "inactive":
+	default:
+		soap.WriteFault(w, env, soap.InvalidArgVal("LogicalState must be active or inactive"))
+		return
+	}
+
+	token := strings.TrimSpace(req.RelayOutputToken)
+	_, outs := deviceIO(cfg, deviceFrom(r).UID)
+	for _, out := range outs {
+		if out.Token != token {
+			continue
+		}
+		if relays == nil {
+			soap.WriteFault(w, env, soap.Action("Relay outputs are not available"))
+			return
+		}
+		if err := relays.SetRelay(out.ID, active); err != nil {
+			soap.WriteFault(w, env, soap.Action(err.Error()))
+			return
+		}
+		prefix, ns := ioPrefix(env)
+		writeSOAP(w, soapEnvelope(fmt.Sprintf(`
+<%s:SetRelayOutputStateResponse xmlns:%s="%s"/>`, prefix, prefix, ns)))
+		return
+	}
+	soap.WriteFault(w, env, &soap.Fault{Code: soap.CodeSender, Subcodes: []string{soap.SubcodeInvalidArgVal, "ter:RelayToken"},
+		Reason: "Unknown relay token " + token})
+}
+
+// registerDeviceIO добавляет операции DeviceIO в таблицу ops.
+func registerDeviceIO(ops *soap.Mux, cfg *config.Config, relays RelayDriver) {
+	getRelayOutputs := func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		_, outs := deviceIO(cfg, deviceFrom(r).UID)
+		prefix, ns := ioPrefix(env)
+		writeSOAP(w, soapResponseGetRelayOutputs(prefix, ns, outs))
+	}
+	setRelayOutputState := func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		handleSetRelayOutputState(w, r, env, cfg, relays)
+	}
+
+	ops.Handle(nsDeviceIO, "GetDigitalInputs", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		ins, _ := deviceIO(cfg, deviceFrom(r).UID)
+		writeSOAP(w, soapResponseGetDigitalInputs(ins))
+	})
+	ops.Handle(nsDeviceIO, "GetRelayOutputs", getRelayOutputs)
+	ops.Handle(nsDevice, "GetRelayOutputs", getRelayOutputs)
+	ops.Handle(nsDeviceIO, "SetRelayOutputState", setRelayOutputState)
+	ops.Handle(nsDevice, "SetRelayOutputState", setRelayOutputState)
+	ops.Handle(nsDeviceIO, "GetServiceCapabilities", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		ins, outs := deviceIO(cfg, deviceFrom(r).UID)
+		writeSOAP(w, soapResponseGetDeviceIOServiceCapabilities(len(ins), len(outs)))
+	})
+}
@@ -0,0 +1,66 @@
+package httpdev
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"sstmk-onvif/internal/devcmd"
+	"sstmk-onvif/internal/soap"
+)
+
+// Commander доставляет команды физическому детектору (devcmd.Dispatcher).
+type Commander interface {
+	Submit(deviceID, typ string, payload any) (devcmd.Command, error)
+}
+
+type setSystemFactoryDefaultRequest struct {
+	FactoryDefault string `xml:"FactoryDefault"` // Hard | Soft
+}
+
+// submitCommand отправляет команду устройству запроса; при ошибке пишет fault
+// и возвращает false.
+func submitCommand(w http.ResponseWriter, r *http.Request, env *soap.Envelope, commands Commander, typ string, payload any) bool {
+	if commands == nil {
+		soap.WriteFault(w, env, soap.Action("Device commands are not available"))
+		return false
+	}
+	if _, err := commands.Submit(deviceFrom(r).UID, typ, payload); err != nil {
+		soap.WriteFault(w, env, soap.Action(err.Error()))
+		return false
+	}
+	return true
+}
+
+func handleSystemReboot(w http.ResponseWriter, r *http.Request, env *soap.Envelope, commands Commander) {
+	if !submitCommand(w, r, env, commands, devcmd.TypeReboot, nil) {
+		return
+	}
+	writeSOAP(w, soapEnvelope(`
+<tds:SystemRebootResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl">
+  <tds:Message>Rebooting in 30 seconds</tds:Message>
+</tds:SystemRebootResponse>`))
+}
+
+func handleSetSystemFactoryDefault(w http.ResponseWriter, r *http.Request, env *soap.Envelope, commands Commander) {
+	var req setSystemFactoryDefaultRequest
+	if err := env.DecodeBody(&req); err != nil {
+		soap.WriteFault(w, env, soap.InvalidArgs(err.Error()))
+		return
+	}
+	var mode string
+	switch strings.TrimSpace(req.FactoryDefault) {
+	case "Hard":
+		mode = "hard"
+	case "Soft":
+		mode = "soft"
+	default:
+		soap.WriteFault(w, env, soap.InvalidArgVal(fmt.Sprintf("FactoryDefault must be Hard or Soft, got %q", req.FactoryDefault)))
+		return
+	}
+	if !submitCommand(w, r, env, commands, devcmd.TypeFactoryReset, map[string]string{"mode": mode}) {
+		return
+	}
+	writeSOAP(w, soapEnvelope(`
+<tds:SetSystemFactoryDefaultResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl"/>`))
+}
@@ -0,0 +1,232 @@
+package httpdev
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/png" // DecodeConfig для снимков зон
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sstmk-onvif/internal/auth"
+	"sstmk-onvif/internal/config"
+	"sstmk-onvif/internal/registry"
+	"sstmk-onvif/internal/snapshot"
+	"sstmk-onvif/internal/soap"
+)
+
+/* ---------- Media / Media2 ----------
+
+Устройство выглядит как камера без потока: один профиль, один видеоисточник
+и снимок — последняя картинка зон детектора. Операции обслуживаются на
+адресе Device Service, снимок — GET snapshot_path на порту устройства.
+*/
+
+const (
+	nsMedia  = "http://www.onvif.org/ver10/media/wsdl"
+	nsMedia2 = "http://www.onvif.org/ver20/media/wsdl"
+
+	mediaProfileToken      = "Profile_1"
+	videoSourceToken       = "VS_1"
+	videoSourceConfigToken = "VSC_1"
+	mediaProfileName       = "Zones"
+
+	// размер картинки generateZoneImage (2×6 клеток по 50 px),
+	// пока снимка ещё нет
+	defaultSnapshotWidth  = 100
+	defaultSnapshotHeight = 300
+)
+
+// snapshotSize — размер последнего снимка устройства.
+func snapshotSize(snaps *snapshot.Store, uid string) (w, h int) {
+	if img, ok := snaps.Get(uid); ok {
+		if c, _, err := image.DecodeConfig(bytes.NewReader(img.PNG)); err == nil {
+			return c.Width, c.Height
+		}
+	}
+	return defaultSnapshotWidth, defaultSnapshotHeight
+}
+
+func videoSourceConfigXML(w, h int) string {
+	return fmt.Sprintf(`<tt:Name>%s</tt:Name><tt:UseCount>1</tt:UseCount><tt:SourceToken>%s</tt:SourceToken><tt:Bounds x="0" y="0" width="%d" height="%d"/>`,
+		mediaProfileName, videoSourceToken, w, h)
+}
+
+func soapResponseGetProfiles(w, h int) string {
+	b := fmt.Sprintf(`
+<trt:GetProfilesResponse xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <trt:Profiles token="%s" fixed="true">
+    <tt:Name>%s</tt:Name>
+    <tt:VideoSourceConfiguration token="%s">%s</tt:VideoSourceConfiguration>
+  </trt:Profiles>
+</trt:GetProfilesResponse>`, mediaProfileToken, mediaProfileName, videoSourceConfigToken, videoSourceConfigXML(w, h))
+	return soapEnvelope(b)
+}
+
+func soapResponseGetProfile(w, h int) string {
+	b := fmt.Sprintf(`
+<trt:GetProfileResponse xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <trt:Profile token="%s" fixed="true">
+    <tt:Name>%s</tt:Name>
+    <tt:VideoSourceConfiguration token="%s">%s</tt:VideoSourceConfiguration>
+  </trt:Profile>
+</trt:GetProfileResponse>`, mediaProfileToken, mediaProfileName, videoSourceConfigToken, videoSourceConfigXML(w, h))
+	return soapEnvelope(b)
+}
+
+func soapResponseGetVideoSources(w, h int) string {
+	b := fmt.Sprintf(`
+<trt:GetVideoSourcesResponse xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <trt:VideoSources token="%s">
+    <tt:Framerate>1</tt:Framerate>
+    <tt:Resolution><tt:Width>%d</tt:Width><tt:Height>%d</tt:Height></tt:Resolution>
+  </trt:VideoSources>
+</trt:GetVideoSourcesResponse>`, videoSourceToken, w, h)
+	return soapEnvelope(b)
+}
+
+func soapResponseGetSnapshotUri(uri string) string {
+	b := fmt.Sprintf(`
+<trt:GetSnapshotUriResponse xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <trt:MediaUri>
+    <tt:Uri>%s</tt:Uri>
+    <tt:InvalidAfterConnect>false</tt:InvalidAfterConnect>
+    <tt:InvalidAfterReboot>false</tt:InvalidAfterReboot>
+    <tt:Timeout>PT0S</tt:Timeout>
+  </trt:MediaUri>
+</trt:GetSnapshotUriResponse>`, xmlText(uri))
+	return soapEnvelope(b)
+}
+
+func soapResponseGetMediaServiceCapabilities() string {
+	return soapEnvelope(`
+<trt:GetServiceCapabilitiesResponse xmlns:trt="http://www.onvif.org/ver10/media/wsdl">
+  <trt:Capabilities SnapshotUri="true" Rotation="false" VideoSourceMode="false" OSD="false">
+    <trt:ProfileCapabilities MaximumNumberOfProfiles="1"/>
+    <trt:StreamingCapabilities RTPMulticast="false" RTP_TCP="false" RTP_RTSP_TCP="false"/>
+  </trt:Capabilities>
+</trt:GetServiceCapabilitiesResponse>`)
+}
+
+func soapResponseGetProfiles2(w, h int) string {
+	b := fmt.Sprintf(`
+<tr2:GetProfilesResponse xmlns:tr2="http://www.onvif.org/ver20/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <tr2:Profiles token="%s" fixed="true">
+    <tr2:Name>%s</tr2:Name>
+    <tr2:Configurations>
+      <tr2:VideoSource token="%s">%s</tr2:VideoSource>
+    </tr2:Configurations>
+  </tr2:Profiles>
+</tr2:GetProfilesResponse>`, mediaProfileToken, mediaProfileName, videoSourceConfigToken, videoSourceConfigXML(w, h))
+	return soapEnvelope(b)
+}
+
+func soapResponseGetSnapshotUri2(uri string) string {
+	b := fmt.Sprintf(`
+<tr2:GetSnapshotUriResponse xmlns:tr2="http://www.onvif.org/ver20/media/wsdl">
+  <tr2:Uri>%s</tr2:Uri>
+</tr2:GetSnapshotUriResponse>`, xmlText(uri))
+	return soapEnvelope(b)
+}
+
+func soapResponseGetMedia2ServiceCapabilities() string {
+	return soapEnvelope(`
+<tr2:GetServiceCapabilitiesResponse xmlns:tr2="http://www.onvif.org/ver20/media/wsdl">
+  <tr2:Capabilities SnapshotUri="true" Rotation="false" VideoSourceMode="false" OSD="false" Mask="false" SourceMask="false">
+    <tr2:ProfileCapabilities MaximumNumberOfProfiles="1" ConfigurationsSupported="VideoSource"/>
+    <tr2:StreamingCapabilities RTSPStreaming="false" RTPMulticast="false" RTSPWebSocketUri=""/>
+  </tr2:Capabilities>
+</tr2:GetServiceCapabilitiesResponse>`)
+}
+
+type profileTokenRequest struct {
+	ProfileToken string `xml:"ProfileToken"`
+}
+
+// checkProfile проверяет ProfileToken запроса; при ошибке отвечает fault.
+// В Media2 ProfileToken у GetProfiles необязателен.
+func checkProfile(w http.ResponseWriter, env *soap.Envelope, optional bool) bool {
+	var req profileTokenRequest
+	if err := env.DecodeBody(&req); err != nil {
+		soap.WriteFault(w, env, soap.InvalidArgs(err.Error()))
+		return false
+	}
+	token := strings.TrimSpace(req.ProfileToken)
+	if token == mediaProfileToken || (optional && token == "") {
+		return true
+	}
+	soap.WriteFault(w, env, &soap.Fault{Code: soap.CodeSender, Subcodes: []string{soap.SubcodeInvalidArgVal, "ter:NoProfile"},
+		Reason: "Profile token does not exist"})
+	return false
+}
+
+// registerMedia добавляет операции Media и Media2 в таблицу ops.
+func registerMedia(ops *soap.Mux, cfg *config.Config, snaps *snapshot.Store) {
+	size := func(r *http.Request) (int, int) { return snapshotSize(snaps, deviceFrom(r).UID) }
+	snapshotURI := func(r *http.Request) string {
+		return cfg.SnapshotURI(advertisedHost(r, cfg.PublicIP), deviceFrom(r))
+	}
+
+	ops.Handle(nsMedia, "GetProfiles", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetProfiles(size(r)))
+	})
+	ops.Handle(nsMedia, "GetProfile", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		if checkProfile(w, env, false) {
+			writeSOAP(w, soapResponseGetProfile(size(r)))
+		}
+	})
+	ops.Handle(nsMedia, "GetVideoSources", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetVideoSources(size(r)))
+	})
+	ops.Handle(nsMedia, "GetSnapshotUri", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		if checkProfile(w, env, false) {
+			writeSOAP(w, soapResponseGetSnapshotUri(snapshotURI(r)))
+		}
+	})
+	ops.Handle(nsMedia, "GetServiceCapabilities", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetMediaServiceCapabilities())
+	})
+
+	ops.Handle(nsMedia2, "GetProfiles", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		if checkProfile(w, env, true) {
+			writeSOAP(w, soapResponseGetProfiles2(size(r)))
+		}
+	})
+	ops.Handle(nsMedia2, "GetSnapshotUri", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		if checkProfile(w, env, false) {
+			writeSOAP(w, soapResponseGetSnapshotUri2(snapshotURI(r)))
+		}
+	})
+	ops.Handle(nsMedia2, "GetServiceCapabilities", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetMedia2ServiceCapabilities())
+	})
+}
+
+// serveSnapshot отдаёт последнюю картинку зон устройства d. При включённой
+// аутентификации нужен HTTP Digest, как и для SOAP-операций.
+func serveSnapshot(w http.ResponseWriter, r *http.Request, d registry.Device, snaps *snapshot.Store, authn *auth.Authenticator) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := authn.Check(r, nil, d.UID); err != nil {
+		authn.Deny(w, r, nil, err)
+		return
+	}
+	img, ok := snaps.Get(d.UID)
+	if !ok {
+		log.Printf("httpdev: %s has no snapshot yet", d.UID)
+		http.Error(w, "no snapshot yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(img.PNG)))
+	w.Header().Set("Last-Modified", img.Time.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(img.PNG)
+}
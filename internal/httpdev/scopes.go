@@ -0,0 +1,123 @@
+package httpdev
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"sstmk-onvif/internal/registry"
+	"sstmk-onvif/internal/soap"
+)
+
+/* ---------- Scopes ----------
+
+Фиксированные scope вычисляются из полей устройства, настраиваемые
+хранятся в реестре (Device.Scopes) и в state.json. Тот же список
+отдаёт WS-Discovery в ProbeMatch.
+*/
+
+type setScopesRequest struct {
+	Scopes []string `xml:"Scopes"`
+}
+
+type scopeItemsRequest struct {
+	ScopeItems []string `xml:"ScopeItem"`
+}
+
+func trimScopes(in []string) []string {
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// scopeFault — ошибка реестра в терминах ONVIF Device Service.
+func scopeFault(err error, fixedSubcode string) *soap.Fault {
+	switch {
+	case errors.Is(err, registry.ErrFixedScope):
+		return &soap.Fault{Code: soap.CodeSender, Subcodes: []string{soap.SubcodeOperationProhibited, fixedSubcode},
+			Reason: "Trying to change a fixed scope"}
+	case errors.Is(err, registry.ErrTooManyScopes):
+		f := soap.Action("The requested scope list exceeds the supported number of scopes")
+		f.Subcodes = append(f.Subcodes, "ter:TooManyScopes")
+		return f
+	case errors.Is(err, registry.ErrNoScope):
+		return &soap.Fault{Code: soap.CodeSender, Subcodes: []string{soap.SubcodeInvalidArgVal, "ter:NoScope"},
+			Reason: "Trying to remove a scope which does not exist"}
+	default:
+		return soap.InvalidArgVal(err.Error())
+	}
+}
+
+func handleSetScopes(w http.ResponseWriter, r *http.Request, env *soap.Envelope, reg *registry.Store) {
+	var req setScopesRequest
+	if err := env.DecodeBody(&req); err != nil {
+		soap.WriteFault(w, env, soap.InvalidArgs(err.Error()))
+		return
+	}
+	if err := reg.SetScopes(deviceFrom(r).UID, trimScopes(req.Scopes)); err != nil {
+		soap.WriteFault(w, env, scopeFault(err, "ter:ScopeOverwrite"))
+		return
+	}
+	writeSOAP(w, soapEnvelope(`
+<tds:SetScopesResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl"/>`))
+}
+
+func handleAddScopes(w http.ResponseWriter, r *http.Request, env *soap.Envelope, reg *registry.Store) {
+	var req scopeItemsRequest
+	if err := env.DecodeBody(&req); err != nil {
+		soap.WriteFault(w, env, soap.InvalidArgs(err.Error()))
+		return
+	}
+	if err := reg.AddScopes(deviceFrom(r).UID, trimScopes(req.ScopeItems)); err != nil {
+		soap.WriteFault(w, env, scopeFault(err, "ter:ScopeOverwrite"))
+		return
+	}
+	writeSOAP(w, soapEnvelope(`
+<tds:AddScopesResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl"/>`))
+}
+
+func handleRemoveScopes(w http.ResponseWriter, r *http.Request, env *soap.Envelope, reg *registry.Store) {
+	var req scopeItemsRequest
+	if err := env.DecodeBody(&req); err != nil {
+		soap.WriteFault(w, env, soap.InvalidArgs(err.Error()))
+		return
+	}
+	items := trimScopes(req.ScopeItems)
+	if err := reg.RemoveScopes(deviceFrom(r).UID, items); err != nil {
+		soap.WriteFault(w, env, scopeFault(err, "ter:FixedScope"))
+		return
+	}
+	var b strings.Builder
+	b.WriteString(`
+<tds:RemoveScopesResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl">`)
+	for _, s := range items {
+		b.WriteString(`
+  <tds:ScopeItem>` + xmlText(s) + `</tds:ScopeItem>`)
+	}
+	b.WriteString(`
+</tds:RemoveScopesResponse>`)
+	writeSOAP(w, soapEnvelope(b.String()))
+}
+
+func soapResponseGetScopesFor(m registry.Device) string {
+	fixed := m.FixedScopes()
+	var b strings.Builder
+	b.WriteString(`
+<tds:GetScopesResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">`)
+	for _, s := range m.AllScopes() {
+		def := "Configurable"
+		if slices.Contains(fixed, s) {
+			def = "Fixed"
+		}
+		b.WriteString(`
+  <tds:Scopes><tt:ScopeDef>` + def + `</tt:ScopeDef><tt:ScopeItem>` + xmlText(s) + `</tt:ScopeItem></tds:Scopes>`)
+	}
+	b.WriteString(`
+</tds:GetScopesResponse>`)
+	return soapEnvelope(b.String())
+}
@@ -3,6 +3,7 @@ package httpdev
 import (
 	"bytes"
 	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
@@ -11,51 +12,44 @@ import (
 	"strings"
 	"time"
 
+	"sstmk-onvif/internal/auth"
 	"sstmk-onvif/internal/config"
+	"sstmk-onvif/internal/onvif"
 	"sstmk-onvif/internal/registry"
+	"sstmk-onvif/internal/snapshot"
+	"sstmk-onvif/internal/soap"
 )
 
-func StartAll(ctx context.Context, cfg *config.Config, reg *registry.Store) error {
-	for _, m := range reg.List() {
-		m := m // capture
-		mux := http.NewServeMux()
-
-		devicePath := cfg.DevicePath
-		eventsPath := cfg.EventsPath
-
-		mux.HandleFunc(devicePath, func(w http.ResponseWriter, r *http.Request) {
-			deviceServiceHandlerFor(w, r, m, cfg.PublicIP, devicePath, eventsPath)
-		})
-		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == devicePath {
-				deviceServiceHandlerFor(w, r, m, cfg.PublicIP, devicePath, eventsPath)
-				return
-			}
-			http.NotFound(w, r)
-		})
+// deviceHandler — HTTP-обработчик устройства uid (его порт или, в режиме
+// single_port, пути /onvif/{uid}/...): Device Service и сервис событий
+// events, ограниченный событиями устройства.
+// Запись устройства берётся из реестра на каждый запрос.
+func deviceHandler(cfg *config.Config, reg *registry.Store, uid string, ops *soap.Mux, events *onvif.EventService, authn *auth.Authenticator, snaps *snapshot.Store) http.Handler {
+	mux := http.NewServeMux()
 
-		srv := &http.Server{
-			Addr:              ":" + m.Port,
-			Handler:           logMiddleware(mux),
-			ReadHeaderTimeout: 5 * time.Second,
-		}
+	devicePath, eventsPath := cfg.ServicePaths(uid)
 
-		go func(id string) {
-			log.Printf("httpdev: %v listening on :%v", id, m.Port)
-			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Printf("httpdev: server %v error: %v", id, err)
-			}
-		}(m.UID)
-
-		// graceful shutdown
-		go func() {
-			<-ctx.Done()
-			shCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-			defer cancel()
-			_ = srv.Shutdown(shCtx)
-		}()
-	}
-	return nil
+	deviceService := serveDevice(reg, uid, func(w http.ResponseWriter, r *http.Request, d registry.Device) {
+		deviceServiceHandlerFor(w, r, ops, authn, d.UID)
+	})
+	eventsHandler := serveDevice(reg, uid, func(w http.ResponseWriter, r *http.Request, d registry.Device) {
+		_, xaddr := cfg.XAddrs(advertisedHost(r, cfg.PublicIP), d)
+		events.ServeHTTP(w, onvif.WithDevice(r, d.UID, xaddr))
+	})
+	mux.HandleFunc(devicePath, deviceService)
+	mux.HandleFunc(cfg.SnapshotPathFor(uid), serveDevice(reg, uid, func(w http.ResponseWriter, r *http.Request, d registry.Device) {
+		serveSnapshot(w, r, d, snaps, authn)
+	}))
+	mux.HandleFunc(eventsPath, eventsHandler)
+	mux.HandleFunc(strings.TrimSuffix(eventsPath, "/")+"/", eventsHandler) // .../subscription/{id}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == devicePath {
+			deviceService(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
+	return logMiddleware(mux)
 }
 
 func logMiddleware(next http.Handler) http.Handler {
@@ -81,15 +75,133 @@ func truncate(b []byte, max int) string {
 	return string(b[:max]) + "\n... [truncated] ..."
 }
 
-func deviceServiceHandlerFor(w http.ResponseWriter, r *http.Request, m registry.Device, pubIP, devicePath, eventsPath string) {
+const nsDevice = "http://www.onvif.org/ver10/device/wsdl"
+
+type deviceKey struct{}
+
+// serveDevice находит устройство uid в реестре и передаёт его h вместе
+// с запросом (см. deviceFrom). Удалённое или выключенное устройство
+// не отвечает — 404, как будто сервиса на порту нет.
+func serveDevice(reg *registry.Store, uid string, h func(http.ResponseWriter, *http.Request, registry.Device)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, ok := reg.Get(uid)
+		if !ok || !d.Enabled {
+			log.Printf("httpdev: %s is disabled or removed, not answering", uid)
+			http.NotFound(w, r)
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), deviceKey{}, d)), d)
+	}
+}
+
+// deviceFrom — запись устройства, положенная в запрос serveDevice.
+func deviceFrom(r *http.Request) registry.Device {
+	d, _ := r.Context().Value(deviceKey{}).(registry.Device)
+	return d
+}
+
+// deviceOperations собирает таблицу операций Device Service. Устройство,
+// к которому обращён запрос, handlers берут из deviceFrom(r).
+func deviceOperations(cfg *config.Config, reg *registry.Store, authn *auth.Authenticator, relays RelayDriver, snaps *snapshot.Store, commands Commander) *soap.Mux {
+	xaddrs := func(r *http.Request) (devX, evX string) {
+		return cfg.XAddrs(advertisedHost(r, cfg.PublicIP), deviceFrom(r))
+	}
+
+	ops := soap.NewMux()
+	ops.Handle(nsDevice, "GetCapabilities", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		devX, evX := xaddrs(r)
+		ins, outs := deviceIO(cfg, deviceFrom(r).UID)
+		writeSOAP(w, soapResponseGetCapabilities(devX, evX, len(ins), len(outs)))
+	})
+	ops.Handle(nsDevice, "GetServices", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetServices(xaddrs(r)))
+	})
+	ops.Handle(nsDevice, "GetScopes", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetScopesFor(deviceFrom(r)))
+	})
+	ops.Handle(nsDevice, "SetScopes", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		handleSetScopes(w, r, env, reg)
+	})
+	ops.Handle(nsDevice, "AddScopes", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		handleAddScopes(w, r, env, reg)
+	})
+	ops.Handle(nsDevice, "RemoveScopes", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		handleRemoveScopes(w, r, env, reg)
+	})
+	ops.Handle(nsDevice, "GetDeviceInformation", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetDeviceInformationFor(deviceFrom(r)))
+	})
+	ops.Handle(nsDevice, "GetEndpointReference", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetEndpointReference(deviceFrom(r)))
+	})
+	ops.Handle(nsDevice, "GetSystemDateAndTime", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetSystemDateAndTime(deviceFrom(r), time.Now()))
+	})
+	ops.Handle(nsDevice, "GetHostname", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetHostname(deviceFrom(r)))
+	})
+	ops.Handle(nsDevice, "GetNetworkInterfaces", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetNetworkInterfaces(cfg.LANIfName))
+	})
+	ops.Handle(nsDevice, "GetDNS", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetDNS(deviceFrom(r)))
+	})
+	ops.Handle(nsDevice, "GetNTP", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetNTP(deviceFrom(r)))
+	})
+	ops.Handle(nsDevice, "GetServiceCapabilities", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		writeSOAP(w, soapResponseGetServiceCapabilities(deviceFrom(r), authn != nil))
+	})
+	ops.Handle(nsDevice, "SystemReboot", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		handleSystemReboot(w, r, env, commands)
+	})
+	ops.Handle(nsDevice, "SetSystemFactoryDefault", func(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+		handleSetSystemFactoryDefault(w, r, env, commands)
+	})
+	registerDeviceIO(ops, cfg, relays)
+	registerMedia(ops, cfg, snaps)
+	return ops
+}
+
+// openOperations доступны без аутентификации (синхронизация часов
+// клиента перед расчётом PasswordDigest).
+var openOperations = map[xml.Name]bool{
+	{Space: nsDevice, Local: "GetSystemDateAndTime"}: true,
+}
+
+func deviceServiceHandlerFor(w http.ResponseWriter, r *http.Request, ops *soap.Mux, authn *auth.Authenticator, deviceID string) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", 405)
 		return
 	}
 	body, _ := io.ReadAll(r.Body)
 	_ = r.Body.Close()
-	req := string(body)
 
+	env, err := soap.Parse(body)
+	if err != nil {
+		log.Printf("httpdev: bad SOAP envelope: %v", err)
+		soap.WriteFault(w, nil, soap.WellFormed(err.Error()))
+		return
+	}
+
+	if !openOperations[env.Operation] {
+		if err := authn.Check(r, env, deviceID); err != nil {
+			authn.Deny(w, r, env, err)
+			return
+		}
+	}
+
+	h, ok := ops.Lookup(env)
+	if !ok {
+		log.Printf("httpdev: unsupported operation %s", env.Operation.Local)
+		soap.WriteFault(w, env, soap.ActionNotSupported(env.Operation.Local))
+		return
+	}
+	h(w, r, env)
+}
+
+// advertisedHost — хост для XAddr: public_ip или Host из запроса.
+func advertisedHost(r *http.Request, pubIP string) string {
 	host := pubIP
 	if host == "" {
 		if h, _, err := net.SplitHostPort(r.Host); err == nil && h != "" {
@@ -99,24 +211,10 @@ func deviceServiceHandlerFor(w http.ResponseWriter, r *http.Request, m registry.
 	if host == "" {
 		host = "127.0.0.1"
 	}
-	base := fmt.Sprintf("http://%s:%s", host, m.Port)
-	devX := base + devicePath
-	evX := base + eventsPath
-
-	var resp string
-	switch {
-	case strings.Contains(req, "<GetCapabilities"):
-		resp = soapResponseGetCapabilities(devX, evX)
-	case strings.Contains(req, "<GetServices"):
-		resp = soapResponseGetServices(devX, evX)
-	case strings.Contains(req, "<GetScopes"):
-		resp = soapResponseGetScopesFor(m)
-	case strings.Contains(req, "<GetDeviceInformation"):
-		resp = soapResponseGetDeviceInformationFor(m)
-	default:
-		resp = soapFaultUnsupported()
-	}
+	return host
+}
 
+func writeSOAP(w http.ResponseWriter, resp string) {
 	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
 	_, _ = w.Write([]byte(resp))
 }
@@ -134,18 +232,36 @@ func soapEnvelope(body string) string {
 </env:Envelope>`, body)
 }
 
-func soapResponseGetCapabilities(deviceXAddr, eventsXAddr string) string {
+// soapResponseGetCapabilities — DeviceIO и Media обслуживаются на адресе Device Service.
+func soapResponseGetCapabilities(deviceXAddr, eventsXAddr string, inputs, relays int) string {
 	b := fmt.Sprintf(`
 <tds:GetCapabilitiesResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl">
   <tds:Capabilities xmlns:tt="http://www.onvif.org/ver10/schema">
-    <tt:Device><tt:XAddr>%s</tt:XAddr></tt:Device>
+    <tt:Device>
+      <tt:XAddr>%s</tt:XAddr>
+      <tt:IO><tt:InputConnectors>%d</tt:InputConnectors><tt:RelayOutputs>%d</tt:RelayOutputs></tt:IO>
+    </tt:Device>
     <tt:Events>
       <tt:XAddr>%s</tt:XAddr>
       <tt:WSSubscriptionPolicySupport>true</tt:WSSubscriptionPolicySupport>
       <tt:WSPullPointSupport>true</tt:WSPullPointSupport>
     </tt:Events>
+    <tt:Media>
+      <tt:XAddr>%s</tt:XAddr>
+      <tt:StreamingCapabilities>
+        <tt:RTPMulticast>false</tt:RTPMulticast><tt:RTP_TCP>false</tt:RTP_TCP><tt:RTP_RTSP_TCP>false</tt:RTP_RTSP_TCP>
+      </tt:StreamingCapabilities>
+    </tt:Media>
+    <tt:Extension>
+      <tt:DeviceIO>
+        <tt:XAddr>%s</tt:XAddr>
+        <tt:VideoSources>0</tt:VideoSources><tt:VideoOutputs>0</tt:VideoOutputs>
+        <tt:AudioSources>0</tt:AudioSources><tt:AudioOutputs>0</tt:AudioOutputs>
+        <tt:RelayOutputs>%d</tt:RelayOutputs>
+      </tt:DeviceIO>
+    </tt:Extension>
   </tds:Capabilities>
-</tds:GetCapabilitiesResponse>`, deviceXAddr, eventsXAddr)
+</tds:GetCapabilitiesResponse>`, deviceXAddr, inputs, relays, eventsXAddr, deviceXAddr, deviceXAddr, relays)
 	return soapEnvelope(b)
 }
 
@@ -162,17 +278,22 @@ func soapResponseGetServices(deviceXAddr, eventsXAddr string) string {
     <tds:XAddr>%s</tds:XAddr>
     <tds:Version><tt:Major>2</tt:Major><tt:Minor>60</tt:Minor></tds:Version>
   </tds:Service>
-</tds:GetServicesResponse>`, deviceXAddr, eventsXAddr)
-	return soapEnvelope(b)
-}
-
-func soapResponseGetScopesFor(m registry.Device) string {
-	b := fmt.Sprintf(`
-<tds:GetScopesResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
-  <tds:Scopes><tt:ScopeDef>Fixed</tt:ScopeDef><tt:ScopeItem>onvif://www.onvif.org/name/%s</tt:ScopeItem></tds:Scopes>
-  <tds:Scopes><tt:ScopeDef>Fixed</tt:ScopeDef><tt:ScopeItem>onvif://www.onvif.org/type/%s</tt:ScopeItem></tds:Scopes>
-  <tds:Scopes><tt:ScopeDef>Fixed</tt:ScopeDef><tt:ScopeItem>onvif://www.onvif.org/location/%s</tt:ScopeItem></tds:Scopes>
-</tds:GetScopesResponse>`, m.Name, m.Model, m.Object)
+  <tds:Service>
+    <tds:Namespace>http://www.onvif.org/ver10/deviceIO/wsdl</tds:Namespace>
+    <tds:XAddr>%s</tds:XAddr>
+    <tds:Version><tt:Major>2</tt:Major><tt:Minor>60</tt:Minor></tds:Version>
+  </tds:Service>
+  <tds:Service>
+    <tds:Namespace>http://www.onvif.org/ver10/media/wsdl</tds:Namespace>
+    <tds:XAddr>%s</tds:XAddr>
+    <tds:Version><tt:Major>2</tt:Major><tt:Minor>60</tt:Minor></tds:Version>
+  </tds:Service>
+  <tds:Service>
+    <tds:Namespace>http://www.onvif.org/ver20/media/wsdl</tds:Namespace>
+    <tds:XAddr>%s</tds:XAddr>
+    <tds:Version><tt:Major>2</tt:Major><tt:Minor>60</tt:Minor></tds:Version>
+  </tds:Service>
+</tds:GetServicesResponse>`, deviceXAddr, eventsXAddr, deviceXAddr, deviceXAddr, deviceXAddr)
 	return soapEnvelope(b)
 }
 
@@ -188,10 +309,11 @@ func soapResponseGetDeviceInformationFor(m registry.Device) string {
 	return soapEnvelope(b)
 }
 
-func soapFaultUnsupported() string {
-	return soapEnvelope(`
-<env:Fault xmlns:env="http://www.w3.org/2003/05/soap-envelope">
-  <env:Code><env:Value>env:Sender</env:Value></env:Code>
-  <env:Reason><env:Text xml:lang="en">Unsupported or unknown request</env:Text></env:Reason>
-</env:Fault>`)
+// soapResponseGetEndpointReference — тот же адрес, что в WS-Discovery ProbeMatch/Hello.
+func soapResponseGetEndpointReference(m registry.Device) string {
+	b := fmt.Sprintf(`
+<tds:GetEndpointReferenceResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl">
+  <tds:GUID>%s</tds:GUID>
+</tds:GetEndpointReferenceResponse>`, xmlText(m.EndpointRef))
+	return soapEnvelope(b)
 }
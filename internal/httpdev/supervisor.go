@@ -0,0 +1,224 @@
+package httpdev
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sstmk-onvif/internal/auth"
+	"sstmk-onvif/internal/config"
+	"sstmk-onvif/internal/onvif"
+	"sstmk-onvif/internal/registry"
+	"sstmk-onvif/internal/snapshot"
+	"sstmk-onvif/internal/soap"
+)
+
+// ListenerStatus — состояние HTTP-сервера устройства.
+type ListenerStatus struct {
+	Port      string `json:"port"`
+	Listening bool   `json:"listening"`
+	Error     string `json:"error,omitempty"` // ошибка bind; сервер не запущен
+}
+
+type deviceServer struct {
+	port string
+	ln   net.Listener
+	srv  *http.Server // nil — bind не удался
+	err  error
+}
+
+// Supervisor держит HTTP-серверы устройств в соответствии с реестром:
+// запускает сервер для нового устройства, останавливает для удалённого,
+// перезапускает на новом порту при смене порта. Неудачный bind
+// повторяется при следующем изменении устройства.
+//
+// В режиме single_port сервер один (onvif.port), а устройства
+// подключаются к нему маршрутами /onvif/{uid}/...
+type Supervisor struct {
+	cfg    *config.Config
+	reg    *registry.Store
+	events *onvif.EventService
+	authn  *auth.Authenticator
+	snaps  *snapshot.Store
+	ops    *soap.Mux
+
+	mu       sync.Mutex
+	running  bool
+	servers  map[string]*deviceServer
+	shared   *deviceServer           // единый сервер single_port
+	handlers map[string]http.Handler // обработчики устройств single_port
+}
+
+// NewSupervisor — authn проверяет учётные данные (nil — без аутентификации),
+// relays переключает реле DeviceIO (nil — реле недоступны), snaps —
+// картинки зон для Media GetSnapshotUri, commands — SystemReboot и
+// SetSystemFactoryDefault на детектор (nil — недоступны).
+func NewSupervisor(cfg *config.Config, reg *registry.Store, events *onvif.EventService, authn *auth.Authenticator, relays RelayDriver, snaps *snapshot.Store, commands Commander) *Supervisor {
+	return &Supervisor{
+		cfg:      cfg,
+		reg:      reg,
+		events:   events,
+		authn:    authn,
+		snaps:    snaps,
+		ops:      deviceOperations(cfg, reg, authn, relays, snaps, commands),
+		servers:  map[string]*deviceServer{},
+		handlers: map[string]http.Handler{},
+	}
+}
+
+// Run поднимает серверы для устройств реестра и следит за его изменениями
+// до отмены ctx, после чего останавливает все серверы.
+func (s *Supervisor) Run(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	if s.cfg.SinglePort() {
+		s.shared = s.listen("onvif", strconv.Itoa(s.cfg.ONVIF.Port), http.HandlerFunc(s.route))
+	}
+	s.mu.Unlock()
+
+	s.reg.OnChange(s.onChange)
+	for _, m := range s.reg.List() {
+		s.sync(m)
+	}
+
+	<-ctx.Done()
+
+	s.mu.Lock()
+	s.running = false
+	servers := s.servers
+	s.servers = map[string]*deviceServer{}
+	shared := s.shared
+	s.shared = nil
+	s.mu.Unlock()
+	for uid, ds := range servers {
+		stopServer(uid, ds)
+	}
+	if shared != nil {
+		stopServer("onvif", shared)
+	}
+	return nil
+}
+
+// route — единый сервер single_port: /onvif/{uid}/... → обработчик устройства.
+func (s *Supervisor) route(w http.ResponseWriter, r *http.Request) {
+	uid, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/onvif/"), "/")
+	s.mu.Lock()
+	h := s.handlers[uid]
+	s.mu.Unlock()
+	if h == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+func (s *Supervisor) onChange(c registry.Change) {
+	if c.Removed {
+		s.remove(c.Prev.UID)
+		return
+	}
+	s.sync(c.Cur)
+}
+
+// sync приводит сервер устройства m к его текущему порту.
+func (s *Supervisor) sync(m registry.Device) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return
+	}
+
+	if s.shared != nil {
+		if s.shared.err != nil {
+			s.shared = s.listen("onvif", strconv.Itoa(s.cfg.ONVIF.Port), http.HandlerFunc(s.route))
+		}
+		if _, ok := s.handlers[m.UID]; !ok {
+			s.handlers[m.UID] = deviceHandler(s.cfg, s.reg, m.UID, s.ops, s.events, s.authn, s.snaps)
+		}
+		return
+	}
+
+	if ds, ok := s.servers[m.UID]; ok {
+		if ds.port == m.Port && ds.err == nil {
+			return
+		}
+		stopServer(m.UID, ds)
+	}
+	s.servers[m.UID] = s.start(m)
+}
+
+func (s *Supervisor) remove(uid string) {
+	s.mu.Lock()
+	ds, ok := s.servers[uid]
+	delete(s.servers, uid)
+	delete(s.handlers, uid)
+	s.mu.Unlock()
+	if ok {
+		stopServer(uid, ds)
+	}
+}
+
+// start поднимает сервер устройства m на его порту.
+func (s *Supervisor) start(m registry.Device) *deviceServer {
+	return s.listen(m.UID, m.Port, deviceHandler(s.cfg, s.reg, m.UID, s.ops, s.events, s.authn, s.snaps))
+}
+
+// listen занимает порт синхронно, чтобы ошибка bind попала в статус.
+func (s *Supervisor) listen(name, port string, h http.Handler) *deviceServer {
+	ds := &deviceServer{port: port}
+	ln, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Printf("httpdev: %v cannot listen on :%v: %v", name, port, err)
+		ds.err = err
+		return ds
+	}
+	ds.ln = ln
+	ds.srv = &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	log.Printf("httpdev: %v listening on :%v", name, port)
+	go func(srv *http.Server) {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("httpdev: server %v error: %v", name, err)
+		}
+	}(ds.srv)
+	return ds
+}
+
+// stopServer закрывает порт сразу, активным запросам даёт 3 секунды.
+func stopServer(uid string, ds *deviceServer) {
+	if ds.srv == nil {
+		return
+	}
+	log.Printf("httpdev: %v stopping :%v", uid, ds.port)
+	_ = ds.ln.Close() // порт свободен сразу — можно занять снова
+	go func() {
+		shCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_ = ds.srv.Shutdown(shCtx)
+	}()
+}
+
+// Status — состояние сервера устройства uid; false — сервер не заводился.
+func (s *Supervisor) Status(uid string) (ListenerStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ds, ok := s.servers[uid]
+	if _, routed := s.handlers[uid]; routed && s.shared != nil {
+		ds, ok = s.shared, true
+	}
+	if !ok {
+		return ListenerStatus{}, false
+	}
+	st := ListenerStatus{Port: ds.port, Listening: ds.srv != nil}
+	if ds.err != nil {
+		st.Error = ds.err.Error()
+	}
+	return st, true
+}
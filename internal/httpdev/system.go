@@ -0,0 +1,250 @@
+package httpdev
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"sstmk-onvif/internal/registry"
+)
+
+/* ---------- Системные операции Device Service ----------
+
+Значения берутся у шлюза (часы, сетевые интерфейсы, resolv.conf);
+поля Hostname/TimeZone/DNS/NTP устройства в реестре их переопределяют.
+*/
+
+const resolvConf = "/etc/resolv.conf"
+
+// deviceLocation — часовой пояс устройства: TimeZone из реестра или пояс шлюза.
+func deviceLocation(m registry.Device) *time.Location {
+	if m.TimeZone != "" {
+		if loc, err := time.LoadLocation(m.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// posixTZ записывает пояс в формате POSIX TZ, который ждёт ONVIF:
+// "MSK-3" для UTC+3 (знак в POSIX обратный).
+func posixTZ(t time.Time) string {
+	name, offset := t.Zone()
+	if name == "" || strings.ContainsAny(name, "+-0123456789") {
+		name = "UTC"
+	}
+	sign := "-"
+	if offset < 0 {
+		sign, offset = "", -offset
+	} else if offset == 0 {
+		sign = ""
+	}
+	h, mnt := offset/3600, offset%3600/60
+	if mnt != 0 {
+		return fmt.Sprintf("%s%s%d:%02d", name, sign, h, mnt)
+	}
+	return fmt.Sprintf("%s%s%d", name, sign, h)
+}
+
+func dateTimeXML(t time.Time) string {
+	return fmt.Sprintf(`<tt:Time><tt:Hour>%d</tt:Hour><tt:Minute>%d</tt:Minute><tt:Second>%d</tt:Second></tt:Time>`+
+		`<tt:Date><tt:Year>%d</tt:Year><tt:Month>%d</tt:Month><tt:Day>%d</tt:Day></tt:Date>`,
+		t.Hour(), t.Minute(), t.Second(), t.Year(), int(t.Month()), t.Day())
+}
+
+func soapResponseGetSystemDateAndTime(m registry.Device, now time.Time) string {
+	local := now.In(deviceLocation(m))
+	dtType := "Manual"
+	if len(m.NTP) > 0 {
+		dtType = "NTP"
+	}
+	b := fmt.Sprintf(`
+<tds:GetSystemDateAndTimeResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <tds:SystemDateAndTime>
+    <tt:DateTimeType>%s</tt:DateTimeType>
+    <tt:DaylightSavings>%t</tt:DaylightSavings>
+    <tt:TimeZone><tt:TZ>%s</tt:TZ></tt:TimeZone>
+    <tt:UTCDateTime>%s</tt:UTCDateTime>
+    <tt:LocalDateTime>%s</tt:LocalDateTime>
+  </tds:SystemDateAndTime>
+</tds:GetSystemDateAndTimeResponse>`, dtType, local.IsDST(), posixTZ(local), dateTimeXML(now.UTC()), dateTimeXML(local))
+	return soapEnvelope(b)
+}
+
+// deviceHostname — Hostname из реестра или имя шлюза.
+func deviceHostname(m registry.Device) string {
+	if m.Hostname != "" {
+		return m.Hostname
+	}
+	if h, err := os.Hostname(); err == nil {
+		return h
+	}
+	return "sstmk-onvif"
+}
+
+func soapResponseGetHostname(m registry.Device) string {
+	b := fmt.Sprintf(`
+<tds:GetHostnameResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <tds:HostnameInformation><tt:FromDHCP>false</tt:FromDHCP><tt:Name>%s</tt:Name></tds:HostnameInformation>
+</tds:GetHostnameResponse>`, xmlText(deviceHostname(m)))
+	return soapEnvelope(b)
+}
+
+// lanInterfaces — поднятые интерфейсы шлюза с IPv4 (кроме loopback).
+// Если задан lan_if и такой интерфейс есть — только он.
+func lanInterfaces(lanIf string) []net.Interface {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []net.Interface
+	for _, ifc := range ifs {
+		if ifc.Flags&net.FlagUp == 0 || ifc.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if lanIf != "" && ifc.Name == lanIf {
+			return []net.Interface{ifc}
+		}
+		out = append(out, ifc)
+	}
+	return out
+}
+
+func ipv4Nets(ifc net.Interface) []*net.IPNet {
+	addrs, err := ifc.Addrs()
+	if err != nil {
+		return nil
+	}
+	var out []*net.IPNet
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+func soapResponseGetNetworkInterfaces(lanIf string) string {
+	var b strings.Builder
+	b.WriteString(`
+<tds:GetNetworkInterfacesResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">`)
+	for _, ifc := range lanInterfaces(lanIf) {
+		nets := ipv4Nets(ifc)
+		if len(nets) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, `
+  <tds:NetworkInterfaces token="%s">
+    <tt:Enabled>true</tt:Enabled>
+    <tt:Info><tt:Name>%s</tt:Name><tt:HwAddress>%s</tt:HwAddress><tt:MTU>%d</tt:MTU></tt:Info>
+    <tt:IPv4>
+      <tt:Enabled>true</tt:Enabled>
+      <tt:Config>`, xmlText(ifc.Name), xmlText(ifc.Name), ifc.HardwareAddr, ifc.MTU)
+		for _, n := range nets {
+			ones, _ := n.Mask.Size()
+			fmt.Fprintf(&b, `
+        <tt:Manual><tt:Address>%s</tt:Address><tt:PrefixLength>%d</tt:PrefixLength></tt:Manual>`, n.IP.To4(), ones)
+		}
+		b.WriteString(`
+        <tt:DHCP>false</tt:DHCP>
+      </tt:Config>
+    </tt:IPv4>
+  </tds:NetworkInterfaces>`)
+	}
+	b.WriteString(`
+</tds:GetNetworkInterfacesResponse>`)
+	return soapEnvelope(b.String())
+}
+
+// systemNameservers — nameserver из /etc/resolv.conf.
+func systemNameservers() []string {
+	f, err := os.Open(resolvConf)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var out []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			out = append(out, fields[1])
+		}
+	}
+	return out
+}
+
+// addressXML — tt:IPAddress или tt:DNSname для элементов DNSManual/NTPManual.
+func addressXML(elem, addr string) string {
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return fmt.Sprintf(`<tt:%s><tt:Type>DNS</tt:Type><tt:DNSname>%s</tt:DNSname></tt:%s>`, elem, xmlText(addr), elem)
+	case ip.To4() != nil:
+		return fmt.Sprintf(`<tt:%s><tt:Type>IPv4</tt:Type><tt:IPv4Address>%s</tt:IPv4Address></tt:%s>`, elem, ip, elem)
+	default:
+		return fmt.Sprintf(`<tt:%s><tt:Type>IPv6</tt:Type><tt:IPv6Address>%s</tt:IPv6Address></tt:%s>`, elem, ip, elem)
+	}
+}
+
+func soapResponseGetDNS(m registry.Device) string {
+	servers := m.DNS
+	if servers == nil {
+		servers = systemNameservers()
+	}
+	var b strings.Builder
+	b.WriteString(`
+<tds:GetDNSResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <tds:DNSInformation><tt:FromDHCP>false</tt:FromDHCP>`)
+	for _, s := range servers {
+		b.WriteString(addressXML("DNSManual", s))
+	}
+	b.WriteString(`</tds:DNSInformation>
+</tds:GetDNSResponse>`)
+	return soapEnvelope(b.String())
+}
+
+func soapResponseGetNTP(m registry.Device) string {
+	var b strings.Builder
+	b.WriteString(`
+<tds:GetNTPResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">
+  <tds:NTPInformation><tt:FromDHCP>false</tt:FromDHCP>`)
+	for _, s := range m.NTP {
+		b.WriteString(addressXML("NTPManual", s))
+	}
+	b.WriteString(`</tds:NTPInformation>
+</tds:GetNTPResponse>`)
+	return soapEnvelope(b.String())
+}
+
+// soapResponseGetServiceCapabilities — возможности Device Service.
+// secured — включена аутентификация (UsernameToken и HTTP Digest).
+func soapResponseGetServiceCapabilities(m registry.Device, secured bool) string {
+	b := fmt.Sprintf(`
+<tds:GetServiceCapabilitiesResponse xmlns:tds="http://www.onvif.org/ver10/device/wsdl">
+  <tds:Capabilities>
+    <tds:Network IPFilter="false" ZeroConfiguration="false" IPVersion6="false" DynDNS="false"
+                 Dot11Configuration="false" HostnameFromDHCP="false" NTP="%d"/>
+    <tds:Security TLS1.0="false" TLS1.1="false" TLS1.2="false" OnboardKeyGeneration="false"
+                  AccessPolicyConfig="false" DefaultAccessPolicy="false" Dot1X="false"
+                  RemoteUserHandling="false" X.509Token="false" SAMLToken="false" KerberosToken="false"
+                  UsernameToken="%t" HttpDigest="%t" RELToken="false"/>
+    <tds:System DiscoveryResolve="true" DiscoveryBye="true" RemoteDiscovery="false"
+                SystemBackup="false" SystemLogging="false" FirmwareUpgrade="false"
+                HttpFirmwareUpgrade="false" HttpSystemBackup="false" HttpSystemLogging="false"
+                HttpSupportInformation="false"/>
+  </tds:Capabilities>
+</tds:GetServiceCapabilitiesResponse>`, len(m.NTP), secured, secured)
+	return soapEnvelope(b)
+}
+
+func xmlText(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
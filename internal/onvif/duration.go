@@ -0,0 +1,92 @@
+package onvif
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseXSDuration разбирает xs:duration (ISO-8601), например "PT10S",
+// "PT1M30S", "PT0.5S" или "P1DT2H". Годы и месяцы не поддерживаются —
+// их длительность неоднозначна, а клиенты ONVIF их не присылают.
+// Обозначения идут по порядку и не повторяются, после "T" нужен хотя бы
+// один компонент времени; недели ("W") — ISO-8601, но не xs:duration.
+func parseXSDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	orig := s
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		neg = true
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 2 {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+	s = s[1:]
+
+	// допустимые обозначения по порядку: D — до T, остальные — после
+	const designators = "DHMS"
+	units := [...]time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+
+	var d time.Duration
+	inTime := false
+	last := -1 // индекс последнего обозначения в designators
+	num := ""
+	for _, c := range s {
+		switch {
+		case c == 'T':
+			if inTime || num != "" {
+				return 0, fmt.Errorf("invalid duration %q", orig)
+			}
+			inTime = true
+		case (c >= '0' && c <= '9') || c == '.':
+			num += string(c)
+		default:
+			if num == "" {
+				return 0, fmt.Errorf("invalid duration %q", orig)
+			}
+			v, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", orig)
+			}
+			num = ""
+
+			i := strings.IndexRune(designators, c)
+			if i < 0 || (i == 0) == inTime {
+				return 0, fmt.Errorf("unsupported duration unit %q in %q", c, orig)
+			}
+			if i <= last {
+				return 0, fmt.Errorf("duration unit %q out of order in %q", c, orig)
+			}
+			last = i
+			d += time.Duration(v * float64(units[i]))
+		}
+	}
+	if num != "" || (inTime && last < 1) {
+		return 0, fmt.Errorf("invalid duration %q", orig)
+	}
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+// parseTerminationTime разбирает wsnt:AbsoluteOrRelativeTimeType:
+// либо xs:duration относительно now, либо абсолютный xs:dateTime.
+func parseTerminationTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "P") || strings.HasPrefix(s, "-P") {
+		d, err := parseXSDuration(s)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(d), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid termination time %q", s)
+}
@@ -0,0 +1,112 @@
+package onvif
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseXSDuration(t *testing.T) {
+	valid := map[string]time.Duration{
+		"PT10S":   10 * time.Second,
+		"PT1M30S": 90 * time.Second,
+		"PT0.5S":  500 * time.Millisecond,
+		"P1DT2H":  26 * time.Hour,
+		"P2D":     48 * time.Hour,
+		"P1DT0S":  24 * time.Hour,
+		" PT1H ":  time.Hour,
+		"-PT5S":   -5 * time.Second,
+		"PT0S":    0,
+	}
+	for in, want := range valid {
+		if got, err := parseXSDuration(in); err != nil || got != want {
+			t.Errorf("parseXSDuration(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"P",
+		"10S",
+		"PTS",
+		"PT5",
+		"P1Y",  // годы неоднозначны
+		"P1M",  // месяц — только после T (минуты)
+		"PT1D", // дни — только до T
+		"P1TT1S",
+		"PT1.2.3S",
+		"PT", // после T нужен компонент времени
+		"P1DT",
+		"-PT",
+		"P1W",    // недель в xs:duration нет
+		"PT1S1M", // обозначения — только по порядку
+		"PT1M1M", // и не повторяются
+		"P1D2D",
+		"PT1H30M1H",
+	}
+	for _, in := range invalid {
+		if got, err := parseXSDuration(in); err == nil {
+			t.Errorf("parseXSDuration(%q) = %v, want error", in, got)
+		}
+	}
+}
+
+func TestParseTerminationTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"PT60S", now.Add(time.Minute)},
+		{"-PT60S", now.Add(-time.Minute)},
+		{"2024-05-01T13:00:00Z", now.Add(time.Hour)},
+		{"2024-05-01T15:00:00+03:00", now},
+		{"2024-05-01T12:00:30.5Z", now.Add(30500 * time.Millisecond)},
+		{"2024-05-01T12:10:00", now.Add(10 * time.Minute)}, // без зоны — UTC
+	}
+	for _, tt := range tests {
+		got, err := parseTerminationTime(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseTerminationTime(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "tomorrow", "2024-05-01", "PTX"} {
+		if _, err := parseTerminationTime(in, now); err == nil {
+			t.Errorf("parseTerminationTime(%q): want error", in)
+		}
+	}
+}
+
+// Время в прошлом (или ровно сейчас) — ошибка: подписка не создаётся
+// и не продлевается. Будущее время ограничивается MinTTL/MaxTTL.
+func TestRequestedTTL(t *testing.T) {
+	es := NewEventService("http://127.0.0.1", Config{
+		DefaultTTL: time.Hour,
+		MinTTL:     10 * time.Second,
+		MaxTTL:     2 * time.Hour,
+	})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	ttls := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", time.Hour},
+		{"  ", time.Hour},
+		{"PT5M", 5 * time.Minute},
+		{"PT1S", 10 * time.Second}, // короче MinTTL
+		{"P1D", 2 * time.Hour},     // длиннее MaxTTL
+		{"2024-05-01T12:30:00Z", 30 * time.Minute},
+	}
+	for _, tt := range ttls {
+		if got, err := es.requestedTTL(tt.in, now); err != nil || got != tt.want {
+			t.Errorf("requestedTTL(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"PT0S", "-PT10S", "2024-05-01T12:00:00Z", "2024-05-01T11:00:00Z", "garbage"} {
+		if got, err := es.requestedTTL(in, now); err == nil {
+			t.Errorf("requestedTTL(%q) = %v, want error", in, got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package onvif
+
+import (
+	"errors"
+	"fmt"
+
+	"sstmk-onvif/internal/soap"
+)
+
+// Ошибки WS-BaseNotification / WS-ResourceFramework сервиса событий.
+
+func resourceUnknownFault(subID string) *soap.Fault {
+	return soap.ResourceUnknown(fmt.Sprintf("Unknown or expired subscription %q", subID))
+}
+
+// invalidFilterFault — wsnt:InvalidFilterFault на фильтр, который
+// сервис не умеет разбирать.
+func invalidFilterFault(err error) *soap.Fault {
+	ferr := &FilterError{Reason: err.Error()}
+	errors.As(err, &ferr)
+	return &soap.Fault{
+		Code:     soap.CodeSender,
+		Subcodes: []string{"wsnt:InvalidFilterFault"},
+		Reason:   ferr.Error(),
+		Detail: soap.BaseFaultDetail("wsnt:InvalidFilterFault",
+			"<wsnt:UnknownFilter>"+xmlEscape(ferr.Expr)+"</wsnt:UnknownFilter>"),
+	}
+}
+
+// unacceptableTerminationTime — недопустимый InitialTerminationTime
+// (CreatePullPointSubscription/Subscribe) или TerminationTime (Renew).
+func unacceptableTerminationTime(initial bool, err error) *soap.Fault {
+	elem := "wsnt:UnacceptableTerminationTimeFault"
+	if initial {
+		elem = "wsnt:UnacceptableInitialTerminationTimeFault"
+	}
+	return &soap.Fault{
+		Code:     soap.CodeSender,
+		Subcodes: []string{elem},
+		Reason:   err.Error(),
+		Detail:   soap.BaseFaultDetail(elem, ""),
+	}
+}
+
+// pullOnlyFault — операция PullPoint на push-подписке (Subscribe): её
+// очередь разбирает pushWorker, PullMessages увёл бы события у потребителя.
+func pullOnlyFault(op, subID string) *soap.Fault {
+	return &soap.Fault{
+		Code:     soap.CodeSender,
+		Subcodes: []string{soap.SubcodeActionNotSupported},
+		Reason:   fmt.Sprintf("%s is not supported by push subscription %q", op, subID),
+	}
+}
+
+func subscribeCreationFailed(reason string) *soap.Fault {
+	return &soap.Fault{
+		Code:     soap.CodeSender,
+		Subcodes: []string{"wsnt:SubscribeCreationFailedFault"},
+		Reason:   reason,
+		Detail:   soap.BaseFaultDetail("wsnt:SubscribeCreationFailedFault", ""),
+	}
+}
@@ -0,0 +1,467 @@
+package onvif
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// Пространство имён ONVIF schema (tt:) для MessageContent
+const nsSchema = "http://www.onvif.org/ver10/schema"
+
+// ourPrefixes — префиксы, которыми записаны наши топики и сообщения.
+// Используются, если клиент не объявил префикс в конверте.
+var ourPrefixes = map[string]string{
+	"tns1": nsTopicsONVIF,
+	"tmk":  nsTopicsSSTMK,
+	"tt":   nsSchema,
+}
+
+// filterXML — tev:Filter / wsnt:Filter из запроса подписки.
+type filterXML struct {
+	TopicExpression []filterExpr `xml:"TopicExpression"`
+	MessageContent  []filterExpr `xml:"MessageContent"`
+}
+
+type filterExpr struct {
+	Dialect string `xml:"Dialect,attr"`
+	Value   string `xml:",chardata"`
+
+	// xmlns:prefix, объявленные на самом выражении; перекрывают
+	// объявления из области видимости Filter
+	prefixes map[string]string
+}
+
+func (e *filterExpr) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v struct {
+		Dialect string `xml:"Dialect,attr"`
+		Value   string `xml:",chardata"`
+	}
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	e.Dialect, e.Value = v.Dialect, v.Value
+	for _, a := range start.Attr {
+		if a.Name.Space != "xmlns" {
+			continue
+		}
+		if e.prefixes == nil {
+			e.prefixes = map[string]string{}
+		}
+		e.prefixes[a.Name.Local] = a.Value
+	}
+	return nil
+}
+
+// scope — префиксы, действующие на выражении.
+func (e filterExpr) scope(outer map[string]string) map[string]string {
+	if len(e.prefixes) == 0 {
+		return outer
+	}
+	out := make(map[string]string, len(outer)+len(e.prefixes))
+	for k, v := range outer {
+		out[k] = v
+	}
+	for k, v := range e.prefixes {
+		out[k] = v
+	}
+	return out
+}
+
+// Filter отбирает сообщения для подписки. nil-фильтр пропускает всё.
+type Filter struct {
+	topics  []topicPattern // альтернативы; пусто — любой топик
+	content []contentExpr  // все выражения должны выполниться
+}
+
+// Match сообщает, нужно ли отдавать сообщение подписчику.
+func (f *Filter) Match(msg *Message) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.topics) > 0 {
+		topic := resolveTopic(msg.Topic, ourPrefixes)
+		ok := false
+		for _, p := range f.topics {
+			if p.match(topic) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, c := range f.content {
+		if !c.eval(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterError — выражение фильтра не поддерживается (InvalidFilterFault).
+type FilterError struct {
+	Expr   string
+	Reason string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("unsupported filter %q: %s", e.Expr, e.Reason)
+}
+
+// parseFilter компилирует фильтр из запроса. prefixes — объявления
+// xmlns, действующие на элементе Filter (soap.Envelope.PrefixesInScope).
+func parseFilter(fx *filterXML, prefixes map[string]string) (*Filter, error) {
+	if fx == nil || (len(fx.TopicExpression) == 0 && len(fx.MessageContent) == 0) {
+		return nil, nil
+	}
+
+	f := &Filter{}
+	for _, te := range fx.TopicExpression {
+		expr := strings.TrimSpace(te.Value)
+		scope := te.scope(prefixes)
+		switch strings.TrimSpace(te.Dialect) {
+		case dialectConcreteSet:
+			for _, alt := range strings.Split(expr, "|") {
+				p, err := parseTopicPattern(strings.TrimSpace(alt), scope, true)
+				if err != nil {
+					return nil, &FilterError{Expr: expr, Reason: err.Error()}
+				}
+				f.topics = append(f.topics, p)
+			}
+		case dialectConcrete:
+			p, err := parseTopicPattern(expr, scope, false)
+			if err != nil {
+				return nil, &FilterError{Expr: expr, Reason: err.Error()}
+			}
+			f.topics = append(f.topics, p)
+		default:
+			return nil, &FilterError{Expr: expr, Reason: "unknown topic expression dialect " + te.Dialect}
+		}
+	}
+
+	for _, mc := range fx.MessageContent {
+		expr := strings.TrimSpace(mc.Value)
+		scope := mc.scope(prefixes)
+		if d := strings.TrimSpace(mc.Dialect); d != dialectItemFilter {
+			return nil, &FilterError{Expr: expr, Reason: "unknown message content dialect " + d}
+		}
+		c, err := parseContentExpr(expr, scope)
+		if err != nil {
+			return nil, &FilterError{Expr: expr, Reason: err.Error()}
+		}
+		f.content = append(f.content, c)
+	}
+	return f, nil
+}
+
+/* ---------- TopicExpression ---------- */
+
+type qname struct{ space, local string }
+
+// topicPattern — путь топика; descendants — окончание "//." (топик
+// и все вложенные).
+type topicPattern struct {
+	path        []qname
+	descendants bool
+}
+
+func (p topicPattern) match(topic []qname) bool {
+	if len(topic) < len(p.path) || (!p.descendants && len(topic) != len(p.path)) {
+		return false
+	}
+	for i := range p.path {
+		if p.path[i] != topic[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func parseTopicPattern(expr string, prefixes map[string]string, allowDescendants bool) (topicPattern, error) {
+	var p topicPattern
+	if allowDescendants && strings.HasSuffix(expr, "//.") {
+		p.descendants = true
+		expr = strings.TrimSuffix(expr, "//.")
+	}
+	if expr == "" || strings.ContainsAny(expr, "*|[]() ") || strings.Contains(expr, "//") {
+		return p, fmt.Errorf("unsupported topic expression")
+	}
+	for _, seg := range strings.Split(expr, "/") {
+		if seg == "" || seg == "." {
+			return p, fmt.Errorf("unsupported topic expression")
+		}
+	}
+	p.path = resolveTopic(expr, prefixes)
+	if p.path[0].space == "" {
+		return p, fmt.Errorf("unknown namespace prefix in %q", expr)
+	}
+	return p, nil
+}
+
+// resolveTopic переводит "pfx:A/B/pfx:C" в список квалифицированных имён.
+// Сегмент без префикса наследует namespace родителя.
+func resolveTopic(path string, prefixes map[string]string) []qname {
+	var out []qname
+	space := ""
+	for _, seg := range strings.Split(path, "/") {
+		if i := strings.Index(seg, ":"); i >= 0 {
+			space = lookupPrefix(seg[:i], prefixes)
+			seg = seg[i+1:]
+		}
+		out = append(out, qname{space: space, local: seg})
+	}
+	return out
+}
+
+func lookupPrefix(prefix string, prefixes map[string]string) string {
+	if ns, ok := prefixes[prefix]; ok {
+		return ns
+	}
+	return ourPrefixes[prefix]
+}
+
+/* ---------- MessageContent (ItemFilter) ----------
+
+Поддерживается практичное подмножество XPath, которое присылают VMS:
+
+	boolean(//tt:SimpleItem[@Name="Id" and @Value="gate-001"])
+	boolean(//tt:Source/tt:SimpleItem[@Name="Id" and @Value="7"])
+	boolean(//tt:Data/tt:SimpleItem[@Name="LogicalState" and @Value="true"])
+
+объединённое через and, or, not(...) и скобки.
+*/
+
+type contentExpr interface {
+	eval(msg *Message) bool
+}
+
+type orExpr struct{ l, r contentExpr }
+type andExpr struct{ l, r contentExpr }
+type notExpr struct{ e contentExpr }
+
+// itemExpr — существует SimpleItem с заданными Name/Value в Source и/или Data.
+type itemExpr struct {
+	inSource, inData bool
+	name, value      *string
+}
+
+func (e orExpr) eval(m *Message) bool  { return e.l.eval(m) || e.r.eval(m) }
+func (e andExpr) eval(m *Message) bool { return e.l.eval(m) && e.r.eval(m) }
+func (e notExpr) eval(m *Message) bool { return !e.e.eval(m) }
+
+func (e itemExpr) eval(m *Message) bool {
+	match := func(items []SimpleItem) bool {
+		for _, it := range items {
+			if (e.name == nil || it.Name == *e.name) && (e.value == nil || it.Value == *e.value) {
+				return true
+			}
+		}
+		return false
+	}
+	return (e.inSource && match(m.Source.SimpleItems)) || (e.inData && match(m.Data.SimpleItems))
+}
+
+type contentParser struct {
+	toks     []string
+	pos      int
+	prefixes map[string]string
+}
+
+func parseContentExpr(expr string, prefixes map[string]string) (contentExpr, error) {
+	toks, err := tokenizeXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &contentParser{toks: toks, prefixes: prefixes}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos])
+	}
+	return e, nil
+}
+
+func (p *contentParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *contentParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *contentParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		return fmt.Errorf("expected %q, got %q", tok, got)
+	}
+	return nil
+}
+
+func (p *contentParser) parseOr() (contentExpr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = orExpr{l, r}
+	}
+	return l, nil
+}
+
+func (p *contentParser) parseAnd() (contentExpr, error) {
+	l, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.next()
+		r, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		l = andExpr{l, r}
+	}
+	return l, nil
+}
+
+func (p *contentParser) parseTerm() (contentExpr, error) {
+	switch tok := p.next(); tok {
+	case "not":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, p.expect(")")
+	case "boolean":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		e, err := p.parseItemPath()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	default:
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+}
+
+// parseItemPath разбирает //[tt:Source/|tt:Data/]tt:SimpleItem[предикат].
+func (p *contentParser) parseItemPath() (contentExpr, error) {
+	if err := p.expect("//"); err != nil {
+		return nil, err
+	}
+	e := itemExpr{inSource: true, inData: true}
+
+	name, err := p.schemaName()
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "Source", "Data":
+		e.inSource, e.inData = name == "Source", name == "Data"
+		if err := p.expect("/"); err != nil {
+			return nil, err
+		}
+		if name, err = p.schemaName(); err != nil {
+			return nil, err
+		}
+	}
+	if name != "SimpleItem" {
+		return nil, fmt.Errorf("unsupported element %q", name)
+	}
+
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	for {
+		attr := p.next()
+		if attr != "@Name" && attr != "@Value" {
+			return nil, fmt.Errorf("unsupported predicate %q", attr)
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		lit := p.next()
+		if !strings.HasPrefix(lit, "'") {
+			return nil, fmt.Errorf("expected string literal, got %q", lit)
+		}
+		v := lit[1:]
+		if attr == "@Name" {
+			e.name = &v
+		} else {
+			e.value = &v
+		}
+		if p.peek() != "and" {
+			break
+		}
+		p.next()
+	}
+	return e, p.expect("]")
+}
+
+// schemaName читает "pfx:Local" и проверяет, что pfx — namespace tt.
+func (p *contentParser) schemaName() (string, error) {
+	tok := p.next()
+	i := strings.Index(tok, ":")
+	if i < 0 || lookupPrefix(tok[:i], p.prefixes) != nsSchema {
+		return "", fmt.Errorf("expected tt: element, got %q", tok)
+	}
+	return tok[i+1:], nil
+}
+
+// tokenizeXPath режет выражение на лексемы. Строковые литералы
+// возвращаются с ведущей одинарной кавычкой: 'value.
+func tokenizeXPath(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '/' && i+1 < len(s) && s[i+1] == '/':
+			toks = append(toks, "//")
+			i += 2
+		case strings.IndexByte("()[]=/", c) >= 0:
+			toks = append(toks, string(c))
+			i++
+		case c == '"' || c == '\'':
+			j := strings.IndexByte(s[i+1:], c)
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated string literal")
+			}
+			toks = append(toks, "'"+s[i+1:i+1+j])
+			i += j + 2
+		default:
+			j := i
+			for j < len(s) && strings.IndexByte(" \t\r\n()[]=/\"'", s[j]) < 0 {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		}
+	}
+	return toks, nil
+}
@@ -0,0 +1,198 @@
+package onvif
+
+import (
+	"errors"
+	"testing"
+
+	"sstmk-onvif/internal/soap"
+)
+
+func topicFilter(dialect, expr string) *filterXML {
+	return &filterXML{TopicExpression: []filterExpr{{Dialect: dialect, Value: expr}}}
+}
+
+func contentFilter(expr string) *filterXML {
+	return &filterXML{MessageContent: []filterExpr{{Dialect: dialectItemFilter, Value: expr}}}
+}
+
+func TestParseFilter(t *testing.T) {
+	input := NewDigitalInputMessage("gate-001", "DI_1", true)
+	status := NewDeviceStatusMessage("gate-002", false)
+	counter := NewPassCounterMessage("gate-001", 3, 1, 2)
+
+	tests := []struct {
+		name   string
+		fx     *filterXML
+		prefix map[string]string
+		match  []*Message
+		skip   []*Message
+	}{
+		{
+			name:  "no filter",
+			fx:    nil,
+			match: []*Message{input, status, counter},
+		},
+		{
+			name:  "concrete set descendants",
+			fx:    topicFilter(dialectConcreteSet, "tmk:MetalDetector//."),
+			match: []*Message{counter},
+			skip:  []*Message{input, status},
+		},
+		{
+			name:  "concrete set alternatives",
+			fx:    topicFilter(dialectConcreteSet, "tns1:Device/Trigger/DigitalInput | tmk:Device/tmk:Status"),
+			match: []*Message{input, status},
+			skip:  []*Message{counter},
+		},
+		{
+			name:   "client prefix",
+			fx:     topicFilter(dialectConcrete, "x:Device/x:Status"),
+			prefix: map[string]string{"x": nsTopicsSSTMK},
+			match:  []*Message{status},
+			skip:   []*Message{input},
+		},
+		{
+			name:   "client prefix overrides ours",
+			fx:     topicFilter(dialectConcrete, "tmk:Device/tmk:Status"),
+			prefix: map[string]string{"tmk": "urn:other"},
+			skip:   []*Message{status},
+		},
+		{
+			name:  "item by source",
+			fx:    contentFilter(`boolean(//tt:Source/tt:SimpleItem[@Name="Id" and @Value="gate-002"])`),
+			match: []*Message{status},
+			skip:  []*Message{counter},
+		},
+		{
+			name:  "item by data",
+			fx:    contentFilter(`boolean(//tt:Data/tt:SimpleItem[@Name='LogicalState' and @Value='true'])`),
+			match: []*Message{input},
+			skip:  []*Message{status},
+		},
+		{
+			name:  "item anywhere",
+			fx:    contentFilter(`boolean(//tt:SimpleItem[@Value="gate-001"])`),
+			match: []*Message{counter},
+			skip:  []*Message{input, status},
+		},
+		{
+			name:  "not, or and parentheses",
+			fx:    contentFilter(`not(boolean(//tt:SimpleItem[@Name="Id"])) or (boolean(//tt:SimpleItem[@Value="gate-002"]))`),
+			match: []*Message{input, status},
+			skip:  []*Message{counter},
+		},
+		{
+			name: "topic and content together",
+			fx: &filterXML{
+				TopicExpression: []filterExpr{{Dialect: dialectConcreteSet, Value: "tmk:Device/tmk:Status"}},
+				MessageContent:  []filterExpr{{Dialect: dialectItemFilter, Value: `boolean(//tt:SimpleItem[@Value="gate-001"])`}},
+			},
+			skip: []*Message{status, counter},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseFilter(tt.fx, tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, msg := range tt.match {
+				if !f.Match(msg) {
+					t.Errorf("%s: not matched", msg.Topic)
+				}
+			}
+			for _, msg := range tt.skip {
+				if f.Match(msg) {
+					t.Errorf("%s: matched", msg.Topic)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		fx     *filterXML
+		prefix map[string]string
+	}{
+		{"unknown topic dialect", topicFilter("urn:xpath", "tns1:Device"), nil},
+		{"wildcard", topicFilter(dialectConcreteSet, "tns1:Device/*"), nil},
+		{"double slash inside", topicFilter(dialectConcreteSet, "tns1:Device//Trigger"), nil},
+		{"descendants in Concrete", topicFilter(dialectConcrete, "tmk:MetalDetector//."), nil},
+		{"empty segment", topicFilter(dialectConcrete, "tmk:Device/"), nil},
+		{"unknown prefix", topicFilter(dialectConcrete, "foo:Device"), nil},
+		{"empty alternative", topicFilter(dialectConcreteSet, "tmk:Device/tmk:Status|"), nil},
+		{"unknown content dialect", &filterXML{MessageContent: []filterExpr{{Dialect: "urn:xpath", Value: "boolean(//tt:SimpleItem)"}}}, nil},
+		{"missing boolean", contentFilter(`//tt:SimpleItem[@Name="Id"]`), nil},
+		{"not tt namespace", contentFilter(`boolean(//x:SimpleItem[@Name="Id"])`), nil},
+		{"tt prefix rebound", contentFilter(`boolean(//tt:SimpleItem[@Name="Id"])`), map[string]string{"tt": "urn:other"}},
+		{"unsupported element", contentFilter(`boolean(//tt:ElementItem[@Name="Id"])`), nil},
+		{"unsupported predicate", contentFilter(`boolean(//tt:SimpleItem[@Type="xs:string"])`), nil},
+		{"number literal", contentFilter(`boolean(//tt:SimpleItem[@Value=1])`), nil},
+		{"unterminated literal", contentFilter(`boolean(//tt:SimpleItem[@Value="1])`), nil},
+		{"unbalanced parentheses", contentFilter(`(boolean(//tt:SimpleItem[@Value="1"])`), nil},
+		{"trailing tokens", contentFilter(`boolean(//tt:SimpleItem[@Value="1"]) xor`), nil},
+		{"dangling and", contentFilter(`boolean(//tt:SimpleItem[@Value="1"]) and`), nil},
+	}
+	for _, tt := range tests {
+		_, err := parseFilter(tt.fx, tt.prefix)
+		var fe *FilterError
+		if !errors.As(err, &fe) {
+			t.Errorf("%s: got %v, want *FilterError", tt.name, err)
+		}
+	}
+}
+
+// Префиксы в тексте выражений берутся из объявлений в их области
+// видимости: объявление того же префикса в другом месте конверта
+// (здесь — в Header) не должно влиять.
+func TestFilterPrefixScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		match bool
+	}{
+		{
+			name:  "declared on expression",
+			body:  `<wsnt:TopicExpression Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet" xmlns:p="http://www.inforion.ru/schemas/sstmk/onvif/topics/sensors">p:Device/p:Status</wsnt:TopicExpression>`,
+			match: true,
+		},
+		{
+			name:  "declared on filter",
+			body:  `<wsnt:TopicExpression Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">f:Device/f:Status</wsnt:TopicExpression>`,
+			match: true,
+		},
+		{
+			name:  "expression overrides filter",
+			body:  `<wsnt:TopicExpression Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet" xmlns:f="urn:other">f:Device/f:Status</wsnt:TopicExpression>`,
+			match: false,
+		},
+		{
+			name:  "header declaration out of scope",
+			body:  `<wsnt:TopicExpression Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">h:Device/h:Status</wsnt:TopicExpression>`,
+			match: false, // h не объявлен для Filter — FilterError
+		},
+	}
+	for _, tt := range tests {
+		raw := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">` +
+			`<s:Header><x:Extra xmlns:x="urn:x" xmlns:h="http://www.inforion.ru/schemas/sstmk/onvif/topics/sensors" xmlns:p="urn:wrong"/></s:Header>` +
+			`<s:Body><tev:CreatePullPointSubscription xmlns:tev="http://www.onvif.org/ver10/events/wsdl">` +
+			`<tev:Filter xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:f="http://www.inforion.ru/schemas/sstmk/onvif/topics/sensors">` +
+			tt.body +
+			`</tev:Filter></tev:CreatePullPointSubscription></s:Body></s:Envelope>`
+		env, err := soap.Parse([]byte(raw))
+		if err != nil {
+			t.Fatalf("%s: soap.Parse: %v", tt.name, err)
+		}
+		var req createPullPointRequest
+		if err := env.DecodeBody(&req); err != nil {
+			t.Fatalf("%s: DecodeBody: %v", tt.name, err)
+		}
+		f, err := parseFilter(req.Filter, env.PrefixesInScope("Filter"))
+		got := err == nil && f.Match(NewDeviceStatusMessage("gate-001", true))
+		if got != tt.match {
+			t.Errorf("%s: match = %v (err %v), want %v", tt.name, got, err, tt.match)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package onvif
+
+import (
+	"strings"
+	"sync"
+)
+
+// Значения tt:Message/@PropertyOperation
+const (
+	propertyInitialized = "Initialized"
+	propertyChanged     = "Changed"
+	propertyDeleted     = "Deleted"
+)
+
+// propertyState — последние значения свойств (топики с IsProperty)
+// по устройству/источнику. Из них строятся снимки Initialized при
+// подписке и SetSynchronizationPoint.
+//
+// mu также сериализует рассылку: снимок и последующие Changed
+// попадают в очередь подписки в правильном порядке.
+type propertyState struct {
+	mu     sync.Mutex
+	values map[string]*Message
+	order  []string // ключи в порядке появления — снимок стабилен
+}
+
+func newPropertyState() *propertyState {
+	return &propertyState{values: make(map[string]*Message)}
+}
+
+// isPropertyTopic сообщает, описан ли топик как свойство.
+func isPropertyTopic(topic string) bool {
+	for _, d := range topicSet {
+		if d.Path == topic {
+			return d.IsProperty
+		}
+	}
+	return false
+}
+
+// propertyKey — устройство, топик и Source сообщения: одно свойство
+// одного источника (токены входов у разных устройств могут совпадать).
+func propertyKey(msg *Message) string {
+	var b strings.Builder
+	b.WriteString(msg.DeviceID + "|" + msg.Topic)
+	for _, it := range msg.Source.SimpleItems {
+		b.WriteString("|" + it.Name + "=" + it.Value)
+	}
+	return b.String()
+}
+
+// update запоминает значение свойства и проставляет PropertyOperation:
+// Initialized для нового источника, Changed при изменении Data.
+// Возвращает false, если значение не изменилось. Вызывается под mu.
+func (ps *propertyState) update(msg *Message) bool {
+	key := propertyKey(msg)
+	prev, ok := ps.values[key]
+	switch {
+	case !ok:
+		msg.PropertyOperation = propertyInitialized
+		ps.order = append(ps.order, key)
+	case sameItems(prev.Data.SimpleItems, msg.Data.SimpleItems):
+		return false
+	default:
+		msg.PropertyOperation = propertyChanged
+	}
+	ps.values[key] = msg
+	return true
+}
+
+// remove забывает свойства устройства deviceID и возвращает их последние
+// значения с PropertyOperation=Deleted для рассылки. Вызывается под mu.
+func (ps *propertyState) remove(deviceID string) []*Message {
+	var deleted []*Message
+	order := ps.order[:0]
+	for _, key := range ps.order {
+		prev := ps.values[key]
+		if prev.DeviceID != deviceID {
+			order = append(order, key)
+			continue
+		}
+		msg := *prev
+		msg.PropertyOperation = propertyDeleted
+		msg.UtcTime = messageTime()
+		deleted = append(deleted, &msg)
+		delete(ps.values, key)
+	}
+	ps.order = order
+	return deleted
+}
+
+// snapshot кладёт в очередь подписки текущие значения всех свойств,
+// которые она должна видеть, с PropertyOperation=Initialized.
+// Вызывается под mu.
+func (ps *propertyState) snapshot(sub *Subscription) int {
+	n := 0
+	for _, key := range ps.order {
+		msg := *ps.values[key]
+		msg.PropertyOperation = propertyInitialized
+		msg.UtcTime = messageTime()
+		if sub.wants(&msg) {
+			sub.AddMessage(&msg)
+			n++
+		}
+	}
+	return n
+}
+
+func sameItems(a, b []SimpleItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
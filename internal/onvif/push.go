@@ -0,0 +1,232 @@
+package onvif
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+
+	"sstmk-onvif/internal/soap"
+)
+
+const (
+	defaultPushTimeout = 5 * time.Second
+	defaultPushRetries = 5
+	pushBatchSize      = 10
+	pushBackoffMax     = 30 * time.Second
+)
+
+type subscribeRequest struct {
+	ConsumerReference struct {
+		Address string `xml:"Address"`
+	} `xml:"ConsumerReference"`
+	Filter                 *filterXML `xml:"Filter"`
+	InitialTerminationTime string     `xml:"InitialTerminationTime"`
+}
+
+// handleSubscribe — WS-BaseNotification Subscribe: события доставляются
+// потребителю через Notify (push), а не забираются PullMessages.
+func (es *EventService) handleSubscribe(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+	reqMsgID := requestMessageID(env)
+
+	action := "http://docs.oasis-open.org/wsn/bw-2/NotificationProducer/SubscribeResponse"
+	header := soapHeader(action, reqMsgID)
+
+	var req subscribeRequest
+	if err := env.DecodeBody(&req); err != nil {
+		soap.WriteFault(w, env, soap.InvalidArgs(err.Error()))
+		return
+	}
+
+	consumer := strings.TrimSpace(req.ConsumerReference.Address)
+	if u, err := url.Parse(consumer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Printf("[ONVIF] Subscribe: bad ConsumerReference %q", consumer)
+		soap.WriteFault(w, env, subscribeCreationFailed(fmt.Sprintf("Invalid ConsumerReference %q", consumer)))
+		return
+	} else if err := checkConsumerHost(r.Context(), u.Hostname()); err != nil {
+		log.Printf("[ONVIF] Subscribe: ConsumerReference %q rejected: %v", consumer, err)
+		soap.WriteFault(w, env, subscribeCreationFailed(fmt.Sprintf("ConsumerReference %q is not allowed: %v", consumer, err)))
+		return
+	}
+
+	now := time.Now()
+	ttl, err := es.requestedTTL(req.InitialTerminationTime, now)
+	if err != nil {
+		log.Printf("[ONVIF] Subscribe: %v", err)
+		soap.WriteFault(w, env, unacceptableTerminationTime(true, err))
+		return
+	}
+
+	filter, err := parseFilter(req.Filter, env.PrefixesInScope("Filter"))
+	if err != nil {
+		soap.WriteFault(w, env, invalidFilterFault(err))
+		return
+	}
+
+	sub := es.newSubscription(r, KindPush, filter)
+	sub.Consumer = consumer
+	es.subscribe(sub, ttl)
+	go es.pushWorker(sub)
+
+	body := fmt.Sprintf(`<wsnt:SubscribeResponse xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2"
+		xmlns:wsa="http://www.w3.org/2005/08/addressing">
+		<wsnt:SubscriptionReference>
+			%s
+		</wsnt:SubscriptionReference>
+		<wsnt:CurrentTime>%s</wsnt:CurrentTime>
+		<wsnt:TerminationTime>%s</wsnt:TerminationTime>
+	</wsnt:SubscribeResponse>`,
+		subscriptionEPR(sub), now.UTC().Format(timeLayout), sub.Expires().UTC().Format(timeLayout))
+
+	writeSOAP12(w, header, body)
+	log.Printf("[ONVIF] Push subscription created: %s -> %s, device=%q, ttl=%s", sub.ID, consumer, sub.DeviceID, ttl)
+}
+
+// checkConsumerHost не пускает Notify на адреса самого сервера и
+// служебные сети: иначе Subscribe позволяет слать POST от имени сервиса
+// на loopback/link-local (SSRF). Имя проверяется по всем адресам, в
+// которые оно разрешается.
+func checkConsumerHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		return checkConsumerIP(ip)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if err := checkConsumerIP(a.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkConsumerIP(ip net.IP) error {
+	switch {
+	case ip.IsLoopback():
+		return fmt.Errorf("loopback address %s", ip)
+	case ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast(), ip.IsInterfaceLocalMulticast():
+		return fmt.Errorf("link-local address %s", ip)
+	case ip.IsUnspecified():
+		return fmt.Errorf("unspecified address %s", ip)
+	}
+	return nil
+}
+
+// newPushClient — HTTP-клиент для Notify. Адрес проверяется повторно при
+// соединении: имя потребителя могло с момента Subscribe разрешиться в
+// другой адрес, а редирект — увести на запрещённый.
+func newPushClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("bad consumer address %q", address)
+			}
+			return checkConsumerIP(ip)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// pushWorker забирает сообщения из очереди подписки и отправляет их
+// потребителю. Живёт, пока подписка не удалена (Unsubscribe/истечение)
+// и сервис не остановлен.
+func (es *EventService) pushWorker(sub *Subscription) {
+	ctx, cancel := context.WithCancel(es.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-sub.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for ctx.Err() == nil {
+		msgs := sub.WaitMessages(ctx, pushBatchSize, es.cfg.MaxPullTimeout)
+		if len(msgs) == 0 {
+			continue
+		}
+		if err := es.deliver(ctx, sub, msgs); err != nil {
+			sub.addDropped(len(msgs))
+			log.Printf("[ONVIF] Notify to %s failed, %d messages dropped: %v", sub.Consumer, len(msgs), err)
+		}
+	}
+	log.Printf("[ONVIF] Push worker for %s stopped", sub.ID)
+}
+
+// deliver отправляет Notify с повторами и экспоненциальной задержкой.
+func (es *EventService) deliver(ctx context.Context, sub *Subscription, msgs []*Message) error {
+	var notifications strings.Builder
+	ref := fmt.Sprintf(`<wsnt:SubscriptionReference xmlns:wsa="http://www.w3.org/2005/08/addressing">%s</wsnt:SubscriptionReference>`,
+		subscriptionEPR(sub))
+	for _, msg := range msgs {
+		notifications.WriteString(notificationXML(msg, ref))
+	}
+
+	header := fmt.Sprintf(
+		`<a:Action>http://docs.oasis-open.org/wsn/bw-2/NotificationConsumer/Notify</a:Action>`+
+			`<a:MessageID>uuid:%s</a:MessageID>`+
+			`<a:To>%s</a:To>`,
+		uuid.New().String(), xmlEscape(sub.Consumer))
+	body := `<wsnt:Notify xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2">` + notifications.String() + `</wsnt:Notify>`
+	envelope := soapEnvelope12(header, body)
+
+	backoff := time.Second
+	var err error
+	for attempt := 1; attempt <= es.cfg.PushRetries; attempt++ {
+		if err = es.postNotify(ctx, sub.Consumer, envelope); err == nil {
+			log.Printf("[ONVIF] Notify: subscription=%s, messages=%d", sub.ID, len(msgs))
+			return nil
+		}
+		log.Printf("[ONVIF] Notify to %s attempt %d/%d: %v", sub.Consumer, attempt, es.cfg.PushRetries, err)
+		if attempt == es.cfg.PushRetries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff < pushBackoffMax {
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+func (es *EventService) postNotify(ctx context.Context, consumer, envelope string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, consumer, strings.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `application/soap+xml; charset=utf-8; action="http://docs.oasis-open.org/wsn/bw-2/NotificationConsumer/Notify"`)
+
+	resp, err := es.pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("consumer returned status %d", resp.StatusCode)
+	}
+	return nil
+}
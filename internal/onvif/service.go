@@ -1,6 +1,8 @@
 package onvif
 
 import (
+	"context"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
@@ -9,6 +11,9 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+
+	"sstmk-onvif/internal/auth"
+	"sstmk-onvif/internal/soap"
 )
 
 type logRW struct {
@@ -34,16 +39,95 @@ func (lw *logRW) Write(p []byte) (int, error) {
 	return lw.ResponseWriter.Write(p)
 }
 
+// Пространства имён сервиса событий
+const (
+	nsEvents = "http://www.onvif.org/ver10/events/wsdl"
+	nsWSNT   = "http://docs.oasis-open.org/wsn/b-2"
+	nsSSTMK  = "http://www.inforion.ru/schemas/sstmk/onvif"
+)
+
+// Формат xs:dateTime в ответах
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+// Config — настройки сервиса событий.
+type Config struct {
+	MaxPullTimeout time.Duration // верхняя граница ожидания в PullMessages
+	DefaultTTL     time.Duration // срок подписки, если клиент его не указал
+	MinTTL         time.Duration // нижняя граница InitialTerminationTime/Renew
+	MaxTTL         time.Duration // верхняя граница InitialTerminationTime/Renew
+	MaxQueue       int           // размер очереди сообщений одной подписки
+	ReaperInterval time.Duration // период удаления истёкших подписок
+	PushTimeout    time.Duration // таймаут одного Notify потребителю
+	PushRetries    int           // число попыток доставки Notify
+
+	Auth *auth.Authenticator // проверка учётных данных; nil — без аутентификации
+}
+
+const (
+	defaultMaxPullTimeout = 60 * time.Second
+	defaultMessageLimit   = 10
+	defaultTTL            = 24 * time.Hour
+	defaultMinTTL         = 10 * time.Second
+	defaultReaperInterval = 30 * time.Second
+)
+
 type EventService struct {
 	subscriptionManager *SubscriptionManager
 	baseURL             string
+	cfg                 Config
+	ops                 *soap.Mux
+	ctx                 context.Context // останавливает push-доставку
+	pushClient          *http.Client
+	props               *propertyState
 }
 
-func NewEventService(baseURL string) *EventService {
-	return &EventService{
-		subscriptionManager: NewSubscriptionManager(),
+func NewEventService(baseURL string, cfg Config) *EventService {
+	if cfg.MaxPullTimeout <= 0 {
+		cfg.MaxPullTimeout = defaultMaxPullTimeout
+	}
+	if cfg.DefaultTTL <= 0 {
+		cfg.DefaultTTL = defaultTTL
+	}
+	if cfg.MinTTL <= 0 {
+		cfg.MinTTL = defaultMinTTL
+	}
+	if cfg.MaxTTL < cfg.DefaultTTL {
+		cfg.MaxTTL = cfg.DefaultTTL
+	}
+	if cfg.ReaperInterval <= 0 {
+		cfg.ReaperInterval = defaultReaperInterval
+	}
+	if cfg.PushTimeout <= 0 {
+		cfg.PushTimeout = defaultPushTimeout
+	}
+	if cfg.PushRetries <= 0 {
+		cfg.PushRetries = defaultPushRetries
+	}
+	es := &EventService{
+		subscriptionManager: NewSubscriptionManager(cfg.MaxQueue),
 		baseURL:             baseURL,
+		cfg:                 cfg,
+		ops:                 soap.NewMux(),
+		ctx:                 context.Background(),
+		pushClient:          newPushClient(cfg.PushTimeout),
+		props:               newPropertyState(),
 	}
+	es.ops.Handle(nsEvents, "GetServiceCapabilities", es.handleGetServiceCapabilities)
+	es.ops.Handle(nsEvents, "GetEventProperties", es.handleGetEventProperties)
+	es.ops.Handle(nsEvents, "CreatePullPointSubscription", es.handleCreatePullPointSubscription)
+	es.ops.Handle(nsEvents, "PullMessages", es.handlePullMessages)
+	es.ops.Handle(nsEvents, "SetSynchronizationPoint", es.handleSetSynchronizationPoint)
+	es.ops.Handle(nsWSNT, "Subscribe", es.handleSubscribe)
+	es.ops.Handle(nsWSNT, "Renew", es.handleRenew)
+	es.ops.Handle(nsWSNT, "Unsubscribe", es.handleUnsubscribe)
+	return es
+}
+
+// Start запускает фоновые задачи сервиса (сборщик истёкших подписок)
+// и привязывает к ctx push-доставку. Вызывается до приёма запросов.
+func (es *EventService) Start(ctx context.Context) {
+	es.ctx = ctx
+	go es.subscriptionManager.StartReaper(ctx, es.cfg.ReaperInterval)
 }
 
 func (es *EventService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -60,53 +144,26 @@ func (es *EventService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	b, _ := io.ReadAll(r.Body)
-	bodyStr := string(b)
-
-	action := extractAction(bodyStr)
-
-	// if strings.Contains(bodyStr, "GetServiceCapabilities") {
-	// 	es.handleGetServiceCapabilities(w, r, bodyStr)
-	// } else if strings.Contains(bodyStr, "CreatePullPointSubscription") {
-	// 	es.handleCreatePullPointSubscription(w, r)
-	// } else if strings.Contains(bodyStr, "PullMessages") {
-	// 	es.handlePullMessages(w, r, bodyStr)
-	// } else {
-	// 	log.Printf("[ONVIF] Unknown SOAP action in body: %s", bodyStr[:min(200, len(bodyStr))])
-	// 	http.Error(w, "Unknown SOAP action", http.StatusBadRequest)
-	// }
 
-	switch {
-	case strings.Contains(action, "GetServiceCapabilitiesRequest") || strings.Contains(bodyStr, "GetServiceCapabilities"):
-		es.handleGetServiceCapabilities(w, r, bodyStr)
-	case strings.Contains(action, "CreatePullPointSubscriptionRequest") || strings.Contains(bodyStr, "CreatePullPointSubscription"):
-		es.handleCreatePullPointSubscription(w, r, bodyStr)
-	case strings.Contains(action, "PullMessagesRequest") || strings.Contains(bodyStr, "PullMessages"):
-		es.handlePullMessages(w, r, bodyStr)
-	default:
-		log.Printf("[ONVIF] Unknown SOAP action in body: %s", bodyStr[:min(200, len(bodyStr))])
-		http.Error(w, "Unknown SOAP action", http.StatusBadRequest)
+	env, err := soap.Parse(b)
+	if err != nil {
+		log.Printf("[ONVIF] Bad SOAP envelope: %v", err)
+		soap.WriteFault(w, nil, soap.WellFormed(err.Error()))
+		return
 	}
-}
 
-func extractAction(soap string) string {
-	// очень простой парсер Action
-	start := strings.Index(soap, "<a:Action")
-	if start == -1 {
-		return ""
-	}
-	start = strings.Index(soap[start:], ">")
-	if start == -1 {
-		return ""
+	if err := es.cfg.Auth.Check(r, env, es.scopeOf(r).deviceID); err != nil {
+		es.cfg.Auth.Deny(w, r, env, err)
+		return
 	}
-	// start сейчас относительный, пересчитаем
-	startAbs := strings.Index(soap, "<a:Action")
-	startAbs = startAbs + strings.Index(soap[startAbs:], ">") + 1
 
-	endAbs := strings.Index(soap[startAbs:], "</a:Action>")
-	if endAbs == -1 {
-		return ""
+	h, ok := es.ops.Lookup(env)
+	if !ok {
+		log.Printf("[ONVIF] Unknown SOAP action: op=%s action=%q", env.Operation.Local, env.Action)
+		soap.WriteFault(w, env, soap.ActionNotSupported(env.Operation.Local))
+		return
 	}
-	return strings.TrimSpace(soap[startAbs : startAbs+endAbs])
+	h(w, r, env)
 }
 
 func normalizeWSAValue(s string) string {
@@ -121,14 +178,14 @@ func normalizeWSAValue(s string) string {
 }
 
 func writeSOAP12(w http.ResponseWriter, headerXML, bodyXML string) {
+	writeSOAP12Status(w, http.StatusOK, headerXML, bodyXML)
+}
+
+func writeSOAP12Status(w http.ResponseWriter, status int, headerXML, bodyXML string) {
 	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 
-	envelope := `<?xml version="1.0" encoding="UTF-8"?>` +
-		`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://www.w3.org/2005/08/addressing">` +
-		`<env:Header>` + headerXML + `</env:Header>` +
-		`<env:Body>` + bodyXML + `</env:Body>` +
-		`</env:Envelope>`
+	envelope := soapEnvelope12(headerXML, bodyXML)
 
 	log.Printf("[ONVIF] RESP CT=%q len=%d head=%q",
 		w.Header().Get("Content-Type"),
@@ -139,101 +196,277 @@ func writeSOAP12(w http.ResponseWriter, headerXML, bodyXML string) {
 	_, _ = w.Write([]byte(envelope))
 }
 
-func (es *EventService) handleCreatePullPointSubscription(w http.ResponseWriter, r *http.Request, reqBody string) {
-	reqMsgID := extractMessageIDFromBody(reqBody)
+func soapEnvelope12(headerXML, bodyXML string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://www.w3.org/2005/08/addressing">` +
+		`<env:Header>` + headerXML + `</env:Header>` +
+		`<env:Body>` + bodyXML + `</env:Body>` +
+		`</env:Envelope>`
+}
+
+// scopeKey — ключ контекста запроса с областью сервиса событий.
+type scopeKey struct{}
+
+type requestScope struct {
+	deviceID string // "" — события всех устройств (порт веб-сервера)
+	xaddr    string // адрес сервиса событий, от которого строятся адреса подписок
+}
+
+// WithDevice привязывает запрос к устройству deviceID: подписки, созданные
+// таким запросом, получают только события этого устройства, а их адреса
+// строятся от xaddr — адреса сервиса событий на порту устройства.
+func WithDevice(r *http.Request, deviceID, xaddr string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), scopeKey{}, requestScope{deviceID: deviceID, xaddr: xaddr}))
+}
+
+// scopeOf — область запроса. Без WithDevice это общий сервис событий на
+// порту веб-сервера: адреса подписок строятся от хоста, на который пришёл
+// запрос (клиент по нему и достучался), а не от адреса SSTMK из конфига.
+func (es *EventService) scopeOf(r *http.Request) requestScope {
+	if sc, ok := r.Context().Value(scopeKey{}).(requestScope); ok {
+		return sc
+	}
+	base := es.baseURL
+	if r.Host != "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return requestScope{xaddr: base + "/onvif/events"}
+}
+
+// newSubscription готовит подписку в области запроса r.
+func (es *EventService) newSubscription(r *http.Request, kind SubscriptionKind, filter *Filter) *Subscription {
+	sc := es.scopeOf(r)
+	id := uuid.New().String()
+	return &Subscription{
+		ID:       id,
+		Kind:     kind,
+		Filter:   filter,
+		DeviceID: sc.deviceID,
+		Address:  fmt.Sprintf("%s/subscription/%s", sc.xaddr, id),
+	}
+}
+
+// subscribe регистрирует подписку и сразу кладёт в её очередь снимок
+// текущих свойств (PropertyOperation=Initialized).
+func (es *EventService) subscribe(sub *Subscription, ttl time.Duration) *Subscription {
+	es.props.mu.Lock()
+	defer es.props.mu.Unlock()
+	es.subscriptionManager.CreateSubscription(sub, ttl)
+	es.props.snapshot(sub)
+	return sub
+}
+
+// lookupSubscription находит подписку, к которой обращается запрос.
+// Подписка чужого устройства считается несуществующей.
+func (es *EventService) lookupSubscription(r *http.Request, env *soap.Envelope) (string, *Subscription) {
+	subID := subscriptionIDFor(r, env)
+	sub := es.subscriptionManager.GetSubscription(subID)
+	if sub == nil || sub.DeviceID != es.scopeOf(r).deviceID {
+		return subID, nil
+	}
+	return subID, sub
+}
+
+// subscriptionEPR — содержимое EndpointReference подписки:
+// адрес и reference parameter с её идентификатором.
+func subscriptionEPR(sub *Subscription) string {
+	return fmt.Sprintf(`<wsa:Address>%s</wsa:Address>
+			<wsa:ReferenceParameters>
+				<sst:SubscriptionId xmlns:sst="%s">%s</sst:SubscriptionId>
+			</wsa:ReferenceParameters>`, xmlEscape(sub.Address), nsSSTMK, sub.ID)
+}
+
+func (es *EventService) handleCreatePullPointSubscription(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+	reqMsgID := requestMessageID(env)
 
 	action := "http://www.onvif.org/ver10/events/wsdl/EventPortType/CreatePullPointSubscriptionResponse"
 	header := soapHeader(action, reqMsgID)
 
-	subID := uuid.New().String()
-	es.subscriptionManager.CreateSubscription(subID, 24*time.Hour)
+	var req createPullPointRequest
+	if err := env.DecodeBody(&req); err != nil {
+		soap.WriteFault(w, env, soap.InvalidArgs(err.Error()))
+		return
+	}
 
-	addr := fmt.Sprintf("%s/onvif/events/subscription/%s", es.baseURL, subID)
-	nowUTC := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
-	termUTC := time.Now().Add(24 * time.Hour).UTC().Format("2006-01-02T15:04:05.000Z")
+	now := time.Now()
+	ttl, err := es.requestedTTL(req.InitialTerminationTime, now)
+	if err != nil {
+		log.Printf("[ONVIF] CreatePullPointSubscription: %v", err)
+		soap.WriteFault(w, env, unacceptableTerminationTime(true, err))
+		return
+	}
+
+	filter, err := parseFilter(req.Filter, env.PrefixesInScope("Filter"))
+	if err != nil {
+		soap.WriteFault(w, env, invalidFilterFault(err))
+		return
+	}
+
+	sub := es.subscribe(es.newSubscription(r, KindPull, filter), ttl)
+
+	nowUTC := now.UTC().Format(timeLayout)
+	termUTC := sub.Expires().UTC().Format(timeLayout)
 
 	body := fmt.Sprintf(`<tev:CreatePullPointSubscriptionResponse
 		xmlns:tev="http://www.onvif.org/ver10/events/wsdl"
 		xmlns:wsa="http://www.w3.org/2005/08/addressing">
 		<tev:SubscriptionReference>
-			<wsa:Address>%s</wsa:Address>
+			%s
 		</tev:SubscriptionReference>
 		<tev:CurrentTime>%s</tev:CurrentTime>
 		<tev:TerminationTime>%s</tev:TerminationTime>
 	</tev:CreatePullPointSubscriptionResponse>`,
-		addr, nowUTC, termUTC)
+		subscriptionEPR(sub), nowUTC, termUTC)
 
 	writeSOAP12(w, header, body)
-	log.Printf("[ONVIF] PullPoint subscription created: %s", subID)
+	log.Printf("[ONVIF] PullPoint subscription created: %s, device=%q, ttl=%s", sub.ID, sub.DeviceID, ttl)
 }
 
-func (es *EventService) handlePullMessages(w http.ResponseWriter, r *http.Request, body string) {
-	reqMsgID := extractMessageIDFromBody(body)
+type createPullPointRequest struct {
+	Filter                 *filterXML `xml:"Filter"`
+	InitialTerminationTime string     `xml:"InitialTerminationTime"`
+}
 
-	action := "http://www.onvif.org/ver10/events/wsdl/PullPointSubscription/PullMessagesResponse"
+type renewRequest struct {
+	TerminationTime string `xml:"TerminationTime"`
+}
+
+// clampTTL приводит запрошенный срок подписки к настроенным границам.
+func (es *EventService) clampTTL(ttl time.Duration) time.Duration {
+	if ttl < es.cfg.MinTTL {
+		return es.cfg.MinTTL
+	}
+	if ttl > es.cfg.MaxTTL {
+		return es.cfg.MaxTTL
+	}
+	return ttl
+}
+
+// requestedTTL переводит запрошенное время окончания подписки в срок
+// от now; пустое значение означает срок по умолчанию. Время не позже now
+// (прошедшее или отрицательная длительность) — ошибка: WS-BaseNotification
+// требует для него Unacceptable(Initial)TerminationTimeFault. В пределы
+// MinTTL/MaxTTL приводится только допустимое будущее время.
+func (es *EventService) requestedTTL(termination string, now time.Time) (time.Duration, error) {
+	if strings.TrimSpace(termination) == "" {
+		return es.cfg.DefaultTTL, nil
+	}
+	t, err := parseTerminationTime(termination, now)
+	if err != nil {
+		return 0, err
+	}
+	if !t.After(now) {
+		return 0, fmt.Errorf("termination time %q is not in the future", strings.TrimSpace(termination))
+	}
+	return es.clampTTL(t.Sub(now)), nil
+}
+
+func (es *EventService) handleRenew(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+	reqMsgID := requestMessageID(env)
+
+	action := "http://docs.oasis-open.org/wsn/bw-2/SubscriptionManager/RenewResponse"
 	header := soapHeader(action, reqMsgID)
 
-	subID := extractSubscriptionID(r.URL.Path)
-	if subID == "" {
-		subID = es.subscriptionManager.AnyActiveSubscriptionID()
+	subID, sub := es.lookupSubscription(r, env)
+	if sub == nil {
+		soap.WriteFault(w, env, resourceUnknownFault(subID))
+		return
 	}
-	if subID == "" {
-		http.Error(w, "No active subscription", http.StatusBadRequest)
+
+	var req renewRequest
+	if err := env.DecodeBody(&req); err != nil {
+		soap.WriteFault(w, env, soap.InvalidArgs(err.Error()))
 		return
 	}
 
-	sub := es.subscriptionManager.GetSubscription(subID)
+	now := time.Now()
+	ttl, err := es.requestedTTL(req.TerminationTime, now)
+	if err != nil {
+		log.Printf("[ONVIF] Renew: %v", err)
+		soap.WriteFault(w, env, unacceptableTerminationTime(false, err))
+		return
+	}
+	sub.Renew(now.Add(ttl))
+
+	body := fmt.Sprintf(`<wsnt:RenewResponse xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2">
+		<wsnt:TerminationTime>%s</wsnt:TerminationTime>
+		<wsnt:CurrentTime>%s</wsnt:CurrentTime>
+	</wsnt:RenewResponse>`,
+		sub.Expires().UTC().Format(timeLayout), now.UTC().Format(timeLayout))
+
+	writeSOAP12(w, header, body)
+	log.Printf("[ONVIF] Subscription renewed: %s, ttl=%s", subID, ttl)
+}
+
+func (es *EventService) handleUnsubscribe(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+	reqMsgID := requestMessageID(env)
+
+	action := "http://docs.oasis-open.org/wsn/bw-2/SubscriptionManager/UnsubscribeResponse"
+	header := soapHeader(action, reqMsgID)
+
+	subID, sub := es.lookupSubscription(r, env)
+	if sub == nil || !es.subscriptionManager.RemoveSubscription(subID) {
+		soap.WriteFault(w, env, resourceUnknownFault(subID))
+		return
+	}
+
+	body := `<wsnt:UnsubscribeResponse xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2"/>`
+
+	writeSOAP12(w, header, body)
+	log.Printf("[ONVIF] Subscription removed: %s", subID)
+}
+
+func (es *EventService) handlePullMessages(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+	reqMsgID := requestMessageID(env)
+
+	action := "http://www.onvif.org/ver10/events/wsdl/PullPointSubscription/PullMessagesResponse"
+	header := soapHeader(action, reqMsgID)
+
+	subID, sub := es.lookupSubscription(r, env)
 	if sub == nil {
-		http.Error(w, "Subscription not found", http.StatusNotFound)
+		soap.WriteFault(w, env, resourceUnknownFault(subID))
+		return
+	}
+	if sub.Kind != KindPull {
+		soap.WriteFault(w, env, pullOnlyFault("PullMessages", subID))
 		return
 	}
 
-	limit := extractMessageLimit(body)
-	if limit == 0 {
-		limit = 10
+	var req pullMessagesRequest
+	if err := env.DecodeBody(&req); err != nil {
+		soap.WriteFault(w, env, soap.InvalidArgs(err.Error()))
+		return
 	}
 
-	messages := sub.PullMessages(limit)
-	nowUTC := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
-	termUTC := sub.TerminationTime.UTC().Format("2006-01-02T15:04:05.000Z")
+	timeout, err := req.timeout(es.cfg.MaxPullTimeout)
+	if err != nil {
+		log.Printf("[ONVIF] PullMessages: %v", err)
+		soap.WriteFault(w, env, soap.InvalidArgVal("Invalid Timeout: "+err.Error()))
+		return
+	}
+	limit := req.MessageLimit
+	if limit <= 0 {
+		limit = defaultMessageLimit
+	}
+
+	messages := sub.WaitMessages(r.Context(), limit, timeout)
+	if r.Context().Err() != nil {
+		log.Printf("[ONVIF] PullMessages: subscription=%s, client gone", subID)
+		return
+	}
+	nowUTC := time.Now().UTC().Format(timeLayout)
+	termUTC := sub.Expires().UTC().Format(timeLayout)
 
 	var notificationsXML strings.Builder
 	for _, msg := range messages {
-		// Извлекаем данные
-		pictureB64 := msg.Data.SimpleItems[0].Value
-		account := msg.Data.SimpleItems[1].Value
-		category := "0" // По умолчанию
-		mesures := ""   // Пустая строка по умолчанию
-
-		eventMessage := fmt.Sprintf(`<tt:Message UtcTime="%s" PropertyOperation="Initialized"
-			xmlns:tt="http://www.onvif.org/ver10/schema">
-			<tt:Source>
-				<tt:SimpleItem Name="Id" Value="%s"/>
-			</tt:Source>
-			<tt:Key/>
-			<tt:Data>
-				<tt:SimpleItem Name="Picture" Value="%s"/>
-				<tt:SimpleItem Name="Category" Value="%s"/>
-				<tt:SimpleItem Name="Mesures" Value="%s"/>
-				<tt:SimpleItem Name="Account" Value="%s"/>
-			</tt:Data>
-		</tt:Message>`,
-			msg.UtcTime,
-			msg.Source.SimpleItem.Value,
-			pictureB64,
-			category,
-			mesures,
-			account)
-
-		notificationsXML.WriteString(fmt.Sprintf(`<wsnt:NotificationMessage>
-			<wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet"
-			 xmlns:tns1="http://www.onvif.org/ver10/topics"
-			 xmlns:tmk="http://www.inforion.ru/schemas/sstmk/onvif/topics/sensors">tmk:MetalDetector/tmk:Detect</wsnt:Topic>
-			%s
-		</wsnt:NotificationMessage>`, eventMessage))
+		notificationsXML.WriteString(notificationXML(msg, ""))
 	}
 
-	body = fmt.Sprintf(`<tev:PullMessagesResponse xmlns:tev="http://www.onvif.org/ver10/events/wsdl"
+	body := fmt.Sprintf(`<tev:PullMessagesResponse xmlns:tev="http://www.onvif.org/ver10/events/wsdl"
 		xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2">
 		<tev:CurrentTime>%s</tev:CurrentTime>
 		<tev:TerminationTime>%s</tev:TerminationTime>
@@ -242,7 +475,46 @@ func (es *EventService) handlePullMessages(w http.ResponseWriter, r *http.Reques
 		nowUTC, termUTC, notificationsXML.String())
 
 	writeSOAP12(w, header, body)
-	log.Printf("[ONVIF] PullMessages: subscription=%s, messages=%d", subID, len(messages))
+	log.Printf("[ONVIF] PullMessages: subscription=%s, messages=%d, dropped=%d", subID, len(messages), sub.DroppedCount())
+}
+
+// notificationXML формирует wsnt:NotificationMessage для PullMessages
+// и Notify. subscriptionRef — EPR подписки (только для Notify).
+func notificationXML(msg *Message, subscriptionRef string) string {
+	return fmt.Sprintf(`<wsnt:NotificationMessage>%s
+			<wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet"
+			 xmlns:tns1="http://www.onvif.org/ver10/topics"
+			 xmlns:tmk="http://www.inforion.ru/schemas/sstmk/onvif/topics/sensors">%s</wsnt:Topic>
+			%s
+		</wsnt:NotificationMessage>`, subscriptionRef, msg.Topic, messageXML(msg))
+}
+
+// messageXML формирует tt:Message; порядок SimpleItem сохраняется
+// (VMS SSTMK разбирает Data по порядку: Picture, Category, Mesures, Account).
+func messageXML(msg *Message) string {
+	items := func(list []SimpleItem) string {
+		var b strings.Builder
+		for _, it := range list {
+			fmt.Fprintf(&b, `
+				<tt:SimpleItem Name="%s" Value="%s"/>`, xmlEscape(it.Name), xmlEscape(it.Value))
+		}
+		return b.String()
+	}
+
+	propOp := ""
+	if msg.PropertyOperation != "" {
+		propOp = fmt.Sprintf(` PropertyOperation="%s"`, msg.PropertyOperation)
+	}
+
+	return fmt.Sprintf(`<tt:Message UtcTime="%s"%s
+			xmlns:tt="http://www.onvif.org/ver10/schema">
+			<tt:Source>%s
+			</tt:Source>
+			<tt:Key/>
+			<tt:Data>%s
+			</tt:Data>
+		</tt:Message>`,
+		msg.UtcTime, propOp, items(msg.Source.SimpleItems), items(msg.Data.SimpleItems))
 }
 
 func soapHeader(action, relatesTo string) string {
@@ -259,8 +531,8 @@ func soapHeader(action, relatesTo string) string {
 	)
 }
 
-func (es *EventService) handleGetServiceCapabilities(w http.ResponseWriter, r *http.Request, bodyStr string) {
-	reqMsgID := extractMessageIDFromBody(bodyStr)
+func (es *EventService) handleGetServiceCapabilities(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+	reqMsgID := requestMessageID(env)
 
 	// Action для ответа (у ONVIF часто именно "...GetServiceCapabilitiesResponse")
 	action := "http://www.onvif.org/ver10/events/wsdl/EventPortType/GetServiceCapabilitiesResponse"
@@ -268,7 +540,7 @@ func (es *EventService) handleGetServiceCapabilities(w http.ResponseWriter, r *h
 
 	// Важно: Capabilities с флагами, чтобы совпадало с Device GetCapabilities.Events.*
 	body := `<tev:GetServiceCapabilitiesResponse xmlns:tev="http://www.onvif.org/ver10/events/wsdl">
-		<tev:Capabilities WSSubscriptionPolicySupport="false"
+		<tev:Capabilities WSSubscriptionPolicySupport="true"
 		                 WSPullPointSupport="true"
 		                 WSPausableSubscriptionManagerInterfaceSupport="false"/>
 	</tev:GetServiceCapabilitiesResponse>`
@@ -276,6 +548,32 @@ func (es *EventService) handleGetServiceCapabilities(w http.ResponseWriter, r *h
 	writeSOAP12(w, header, body)
 }
 
+func (es *EventService) handleGetEventProperties(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+	reqMsgID := requestMessageID(env)
+
+	action := "http://www.onvif.org/ver10/events/wsdl/EventPortType/GetEventPropertiesResponse"
+	header := soapHeader(action, reqMsgID)
+
+	body := fmt.Sprintf(`<tev:GetEventPropertiesResponse xmlns:tev="http://www.onvif.org/ver10/events/wsdl"
+		xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2"
+		xmlns:wstop="http://docs.oasis-open.org/wsn/t-1"
+		xmlns:tt="http://www.onvif.org/ver10/schema"
+		xmlns:xs="http://www.w3.org/2001/XMLSchema"
+		xmlns:tns1="%s"
+		xmlns:tmk="%s">
+		<tev:TopicNamespaceLocation>http://www.onvif.org/onvif/ver10/topics/topicns.xml</tev:TopicNamespaceLocation>
+		<wsnt:FixedTopicSet>true</wsnt:FixedTopicSet>
+		%s
+		<wsnt:TopicExpressionDialect>%s</wsnt:TopicExpressionDialect>
+		<wsnt:TopicExpressionDialect>%s</wsnt:TopicExpressionDialect>
+		<tev:MessageContentFilterDialect>%s</tev:MessageContentFilterDialect>
+		<tev:MessageContentSchemaLocation>http://www.onvif.org/onvif/ver10/schema/onvif.xsd</tev:MessageContentSchemaLocation>
+	</tev:GetEventPropertiesResponse>`,
+		nsTopicsONVIF, nsTopicsSSTMK, topicSetXML(), dialectConcreteSet, dialectConcrete, dialectItemFilter)
+
+	writeSOAP12(w, header, body)
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -283,27 +581,87 @@ func min(a, b int) int {
 	return b
 }
 
-func (es *EventService) PublishEvent(deviceID, image, account string) {
-	msg := NewMetalDetectorMessage(deviceID, image, account)
+// PublishEvent рассылает срабатывание детектора deviceID и состояние
+// его тревоги (свойство: Changed только при переходе).
+func (es *EventService) PublishEvent(deviceID string, d Detection) {
+	es.Publish(NewMetalDetectorMessage(deviceID, d))
+	es.Publish(NewMetalAlarmMessage(deviceID, d.AlarmActive()))
+}
+
+// RemoveDevice вызывается при удалении устройства из реестра: подписчики
+// получают PropertyOperation=Deleted для его свойств, и они больше не
+// попадают в снимки Initialized.
+func (es *EventService) RemoveDevice(deviceID string) {
+	es.props.mu.Lock()
+	defer es.props.mu.Unlock()
+
+	for _, msg := range es.props.remove(deviceID) {
+		es.subscriptionManager.BroadcastMessage(msg)
+	}
+	log.Printf("[ONVIF] Properties of device %q deleted", deviceID)
+}
+
+// Publish рассылает сообщение подписчикам. Для топиков-свойств
+// запоминает значение и проставляет PropertyOperation (Initialized/
+// Changed); неизменившееся значение не рассылается.
+func (es *EventService) Publish(msg *Message) {
+	es.props.mu.Lock()
+	defer es.props.mu.Unlock()
+
+	if isPropertyTopic(msg.Topic) {
+		if !es.props.update(msg) {
+			return
+		}
+	} else {
+		msg.PropertyOperation = ""
+	}
 	es.subscriptionManager.BroadcastMessage(msg)
-	log.Printf("[ONVIF] Event published for device %s", deviceID)
+	log.Printf("[ONVIF] Event published: %s device=%q %s", msg.Topic, msg.DeviceID, msg.PropertyOperation)
 }
 
-func formatMessage(msg *Message) string {
-	return fmt.Sprintf(`<tt:Message UtcTime="%s" PropertyOperation="%s" xmlns:tt="http://www.onvif.org/ver10/schema">
-			<tt:Source>
-				<tt:SimpleItem Name="%s" Value="%s" />
-			</tt:Source>
-			<tt:Key />
-			<tt:Data>
-				<tt:SimpleItem Name="%s" Value="%s" />
-				<tt:SimpleItem Name="%s" Value="%s" />
-			</tt:Data>
-		</tt:Message>`,
-		msg.UtcTime, msg.PropertyOperation,
-		msg.Source.SimpleItem.Name, msg.Source.SimpleItem.Value,
-		msg.Data.SimpleItems[0].Name, msg.Data.SimpleItems[0].Value,
-		msg.Data.SimpleItems[1].Name, msg.Data.SimpleItems[1].Value)
+// handleSetSynchronizationPoint повторно отправляет подписке текущие
+// значения всех свойств с PropertyOperation=Initialized.
+func (es *EventService) handleSetSynchronizationPoint(w http.ResponseWriter, r *http.Request, env *soap.Envelope) {
+	reqMsgID := requestMessageID(env)
+
+	action := "http://www.onvif.org/ver10/events/wsdl/PullPointSubscription/SetSynchronizationPointResponse"
+	header := soapHeader(action, reqMsgID)
+
+	subID, sub := es.lookupSubscription(r, env)
+	if sub == nil {
+		soap.WriteFault(w, env, resourceUnknownFault(subID))
+		return
+	}
+	if sub.Kind != KindPull {
+		soap.WriteFault(w, env, pullOnlyFault("SetSynchronizationPoint", subID))
+		return
+	}
+
+	es.props.mu.Lock()
+	n := es.props.snapshot(sub)
+	es.props.mu.Unlock()
+
+	body := `<tev:SetSynchronizationPointResponse xmlns:tev="http://www.onvif.org/ver10/events/wsdl"/>`
+
+	writeSOAP12(w, header, body)
+	log.Printf("[ONVIF] SetSynchronizationPoint: subscription=%s, properties=%d", subID, n)
+}
+
+// subscriptionIDFor определяет подписку, к которой обращается клиент:
+// по адресу ".../subscription/{id}" или по reference parameter
+// sst:SubscriptionId из заголовка (для клиентов, которые отбрасывают путь).
+// Никаких догадок — если ссылки нет, подписка не найдена.
+func subscriptionIDFor(r *http.Request, env *soap.Envelope) string {
+	if id := extractSubscriptionID(r.URL.Path); id != "" {
+		return id
+	}
+	return env.HeaderValue(nsSSTMK, "SubscriptionId")
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
 }
 
 func extractSubscriptionID(path string) string {
@@ -338,34 +696,34 @@ func generateUUID() string {
 	return uuid.New().String()
 }
 
-func extractMessageIDFromBody(bodyStr string) string {
-	// у тебя была эта функция, но она ищет "<a:MessageID>".
-	// В логах MessageID может быть с переносом строки, поэтому делаем чуть устойчивее:
-	start := strings.Index(bodyStr, "<a:MessageID")
-	if start == -1 {
+// requestMessageID возвращает MessageID запроса для RelatesTo ответа.
+// Если клиент его не прислал — генерируем, как и раньше.
+func requestMessageID(env *soap.Envelope) string {
+	if env.MessageID == "" {
 		return "uuid:" + uuid.New().String()
 	}
-	start = strings.Index(bodyStr[start:], ">")
-	if start == -1 {
-		return "uuid:" + uuid.New().String()
-	}
-	// start относительный — пересчёт
-	startAbs := strings.Index(bodyStr, "<a:MessageID")
-	startAbs = startAbs + strings.Index(bodyStr[startAbs:], ">") + 1
+	return normalizeWSAValue(env.MessageID)
+}
 
-	endAbs := strings.Index(bodyStr[startAbs:], "</a:MessageID>")
-	if endAbs == -1 {
-		return "uuid:" + uuid.New().String()
-	}
-	return normalizeWSAValue(bodyStr[startAbs : startAbs+endAbs])
-	// return strings.TrimSpace(bodyStr[startAbs : startAbs+endAbs])
+type pullMessagesRequest struct {
+	Timeout      string `xml:"Timeout"`
+	MessageLimit int    `xml:"MessageLimit"`
 }
 
-func extractMessageLimit(body string) int {
-	// Simple extraction of MessageLimit from SOAP body
-	if strings.Contains(body, "MessageLimit") {
-		// This is a simplified implementation
-		return 10
+// timeout возвращает время ожидания из запроса, ограниченное max.
+func (req pullMessagesRequest) timeout(max time.Duration) (time.Duration, error) {
+	if strings.TrimSpace(req.Timeout) == "" {
+		return 0, nil
+	}
+	d, err := parseXSDuration(req.Timeout)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative Timeout %q", req.Timeout)
+	}
+	if d > max {
+		d = max
 	}
-	return 10
+	return d, nil
 }
@@ -1,21 +1,58 @@
 package onvif
 
 import (
+	"context"
+	"log"
 	"sync"
 	"time"
 )
 
+const defaultMaxQueue = 100
+
+// SubscriptionKind — способ доставки событий подписки.
+type SubscriptionKind string
+
+const (
+	KindPull SubscriptionKind = "pull" // CreatePullPointSubscription, события забирает PullMessages
+	KindPush SubscriptionKind = "push" // Subscribe, события отправляет pushWorker (Notify)
+)
+
 type Subscription struct {
 	ID              string
 	Messages        []*Message
 	TerminationTime time.Time
+	Dropped         uint64           // сколько сообщений выкинуто из-за переполнения очереди
+	Kind            SubscriptionKind // pull или push; PullMessages допустим только для pull
+	Consumer        string           // адрес NotificationConsumer для push-подписки (Subscribe)
+	Filter          *Filter          // отбор по топику и содержимому; nil — все события
+	DeviceID        string           // устройство, через порт которого создана подписка; "" — все устройства
+	Address         string           // адрес менеджера подписки (wsa:Address в EPR)
 	mu              sync.Mutex
+	maxQueue        int
+	notify          chan struct{} // сигнал ожидающему PullMessages
+	done            chan struct{} // закрывается при удалении подписки
 }
 
 func (s *Subscription) AddMessage(msg *Message) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+
+	// Очередь ограничена: клиент, который перестал забирать события,
+	// не должен съесть всю память роутера. Выкидываем самые старые.
+	if s.maxQueue > 0 && len(s.Messages) >= s.maxQueue {
+		drop := len(s.Messages) - s.maxQueue + 1
+		s.Messages = s.Messages[drop:]
+		s.Dropped += uint64(drop)
+		if s.Dropped == 1 || s.Dropped%100 == 0 {
+			log.Printf("[ONVIF] Subscription %s queue full, dropped=%d", s.ID, s.Dropped)
+		}
+	}
 	s.Messages = append(s.Messages, msg)
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
 }
 
 func (s *Subscription) PullMessages(limit int) []*Message {
@@ -38,56 +75,169 @@ func (s *Subscription) PullMessages(limit int) []*Message {
 	return result
 }
 
+// WaitMessages блокируется, пока в очереди не появятся сообщения,
+// не истечёт timeout, не удалят подписку или не отменится ctx
+// (клиент отключился).
+func (s *Subscription) WaitMessages(ctx context.Context, limit int, timeout time.Duration) []*Message {
+	if msgs := s.PullMessages(limit); len(msgs) > 0 || timeout <= 0 {
+		return msgs
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.notify:
+			if msgs := s.PullMessages(limit); len(msgs) > 0 {
+				return msgs
+			}
+		case <-timer.C:
+			return s.PullMessages(limit)
+		case <-s.done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Expires возвращает текущее время окончания подписки.
+func (s *Subscription) Expires() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.TerminationTime
+}
+
+// Expired сообщает, истекла ли подписка к моменту now.
+func (s *Subscription) Expired(now time.Time) bool {
+	return !now.Before(s.Expires())
+}
+
+// Renew переносит время окончания подписки.
+func (s *Subscription) Renew(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.TerminationTime = t
+}
+
+// wants сообщает, относится ли сообщение к подписке: подписка,
+// созданная через порт устройства, видит только его события.
+func (s *Subscription) wants(msg *Message) bool {
+	if s.DeviceID != "" && s.DeviceID != msg.DeviceID {
+		return false
+	}
+	return s.Filter.Match(msg)
+}
+
+func (s *Subscription) addDropped(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Dropped += uint64(n)
+}
+
+// DroppedCount возвращает счётчик выкинутых сообщений.
+func (s *Subscription) DroppedCount() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Dropped
+}
+
 type SubscriptionManager struct {
 	subscriptions map[string]*Subscription
+	maxQueue      int
 	mu            sync.RWMutex
 }
 
-func NewSubscriptionManager() *SubscriptionManager {
+func NewSubscriptionManager(maxQueue int) *SubscriptionManager {
+	if maxQueue <= 0 {
+		maxQueue = defaultMaxQueue
+	}
 	return &SubscriptionManager{
 		subscriptions: make(map[string]*Subscription),
+		maxQueue:      maxQueue,
 	}
 }
 
-func (sm *SubscriptionManager) CreateSubscription(id string, ttl time.Duration) *Subscription {
+// CreateSubscription регистрирует подписку. Вызывающий заполняет ID,
+// Filter, DeviceID, Address и Consumer заранее: после регистрации
+// подписка сразу начинает получать сообщения.
+func (sm *SubscriptionManager) CreateSubscription(sub *Subscription, ttl time.Duration) *Subscription {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	sub := &Subscription{
-		ID:              id,
-		Messages:        make([]*Message, 0),
-		TerminationTime: time.Now().Add(ttl),
-	}
+	sub.Messages = make([]*Message, 0)
+	sub.TerminationTime = time.Now().Add(ttl)
+	sub.maxQueue = sm.maxQueue
+	sub.notify = make(chan struct{}, 1)
+	sub.done = make(chan struct{})
 
-	sm.subscriptions[id] = sub
+	sm.subscriptions[sub.ID] = sub
 	return sub
 }
 
+// GetSubscription возвращает активную подписку; истёкшие считаются
+// отсутствующими, даже если сборщик их ещё не удалил.
 func (sm *SubscriptionManager) GetSubscription(id string) *Subscription {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
-	return sm.subscriptions[id]
+	sub := sm.subscriptions[id]
+	if sub == nil || sub.Expired(time.Now()) {
+		return nil
+	}
+	return sub
+}
+
+// RemoveSubscription удаляет подписку и будит ожидающий PullMessages.
+func (sm *SubscriptionManager) RemoveSubscription(id string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sub, ok := sm.subscriptions[id]
+	if !ok {
+		return false
+	}
+	delete(sm.subscriptions, id)
+	close(sub.done)
+	return true
 }
 
 func (sm *SubscriptionManager) BroadcastMessage(msg *Message) {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
+	now := time.Now()
 	for _, sub := range sm.subscriptions {
-		if time.Now().Before(sub.TerminationTime) {
+		if !sub.Expired(now) && sub.wants(msg) {
 			sub.AddMessage(msg)
 		}
 	}
 }
 
-func (sm *SubscriptionManager) AnyActiveSubscriptionID() string {
-	sm.mu.RLock()
-	defer sm.mu.RUnlock()
+// removeExpired удаляет истёкшие подписки вместе с их очередями.
+func (sm *SubscriptionManager) removeExpired(now time.Time) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 
 	for id, sub := range sm.subscriptions {
-		if time.Now().Before(sub.TerminationTime) {
-			return id
+		if sub.Expired(now) {
+			delete(sm.subscriptions, id)
+			close(sub.done)
+			log.Printf("[ONVIF] Subscription %s expired, removed (dropped=%d)", id, sub.DroppedCount())
+		}
+	}
+}
+
+// StartReaper периодически удаляет истёкшие подписки до отмены ctx.
+func (sm *SubscriptionManager) StartReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sm.removeExpired(now)
 		}
 	}
-	return ""
 }
@@ -0,0 +1,154 @@
+package onvif
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Пространства имён топиков
+const (
+	nsTopicsONVIF = "http://www.onvif.org/ver10/topics"
+	nsTopicsSSTMK = "http://www.inforion.ru/schemas/sstmk/onvif/topics/sensors"
+)
+
+// Топики событий (ConcreteSet)
+const (
+	TopicMetalDetect  = "tmk:MetalDetector/tmk:Detect"
+	TopicMetalAlarm   = "tmk:MetalDetector/tmk:Alarm"
+	TopicPassCounter  = "tmk:MetalDetector/tmk:PassCounter"
+	TopicDigitalInput = "tns1:Device/Trigger/DigitalInput"
+	TopicDeviceStatus = "tmk:Device/tmk:Status"
+)
+
+// Диалекты выражений фильтра
+const (
+	dialectConcreteSet = "http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet"
+	dialectConcrete    = "http://docs.oasis-open.org/wsn/t-1/TopicExpression/Concrete"
+	dialectItemFilter  = "http://www.onvif.org/ver10/tev/messageContentFilter/ItemFilter"
+)
+
+type itemDescr struct {
+	Name string
+	Type string
+}
+
+type topicDef struct {
+	Path       string
+	IsProperty bool
+	Source     []itemDescr
+	Data       []itemDescr
+}
+
+// topicSet — все топики, которые публикует сервис (GetEventProperties).
+var topicSet = []topicDef{
+	{
+		Path:   TopicMetalDetect,
+		Source: []itemDescr{{"Id", "xs:string"}},
+		Data: []itemDescr{
+			{"Picture", "xs:base64Binary"},
+			{"Category", "xs:string"},
+			{"Mesures", "xs:string"},
+			{"Account", "xs:string"},
+		},
+	},
+	{
+		Path:       TopicMetalAlarm,
+		IsProperty: true,
+		Source:     []itemDescr{{"Id", "xs:string"}},
+		Data:       []itemDescr{{"State", "xs:boolean"}},
+	},
+	{
+		Path:       TopicPassCounter,
+		IsProperty: true,
+		Source:     []itemDescr{{"Id", "xs:string"}},
+		Data: []itemDescr{
+			{"In", "xs:unsignedInt"},
+			{"Out", "xs:unsignedInt"},
+			{"Inside", "xs:unsignedInt"},
+		},
+	},
+	{
+		Path:       TopicDigitalInput,
+		IsProperty: true,
+		Source:     []itemDescr{{"InputToken", "tt:ReferenceToken"}},
+		Data:       []itemDescr{{"LogicalState", "xs:boolean"}},
+	},
+	{
+		Path:       TopicDeviceStatus,
+		IsProperty: true,
+		Source:     []itemDescr{{"Id", "xs:string"}},
+		Data:       []itemDescr{{"Online", "xs:boolean"}},
+	},
+}
+
+// topicSegments разбивает путь топика на элементы с префиксами.
+// Сегмент без префикса наследует префикс родителя:
+// "tns1:Device/Trigger" → ["tns1:Device", "tns1:Trigger"].
+func topicSegments(path string) []string {
+	parts := strings.Split(path, "/")
+	prefix := ""
+	for i, p := range parts {
+		if j := strings.Index(p, ":"); j >= 0 {
+			prefix = p[:j]
+		} else if prefix != "" {
+			parts[i] = prefix + ":" + p
+		}
+	}
+	return parts
+}
+
+// topicSetXML строит wstop:TopicSet из topicSet.
+func topicSetXML() string {
+	var b strings.Builder
+	b.WriteString(`<wstop:TopicSet>`)
+	writeTopicLevel(&b, topicSet, 0)
+	b.WriteString(`</wstop:TopicSet>`)
+	return b.String()
+}
+
+// writeTopicLevel выводит уровень depth дерева топиков,
+// группируя соседние топики с общим родителем.
+func writeTopicLevel(b *strings.Builder, defs []topicDef, depth int) {
+	var order []string
+	groups := map[string][]topicDef{}
+	for _, d := range defs {
+		segs := topicSegments(d.Path)
+		if len(segs) <= depth {
+			continue
+		}
+		if _, ok := groups[segs[depth]]; !ok {
+			order = append(order, segs[depth])
+		}
+		groups[segs[depth]] = append(groups[segs[depth]], d)
+	}
+
+	for _, name := range order {
+		fmt.Fprintf(b, `<%s`, name)
+		var leaf *topicDef
+		for i, d := range groups[name] {
+			if len(topicSegments(d.Path)) == depth+1 {
+				leaf = &groups[name][i]
+			}
+		}
+		if leaf != nil {
+			b.WriteString(` wstop:topic="true">`)
+			writeMessageDescription(b, leaf)
+		} else {
+			b.WriteString(`>`)
+		}
+		writeTopicLevel(b, groups[name], depth+1)
+		fmt.Fprintf(b, `</%s>`, name)
+	}
+}
+
+func writeMessageDescription(b *strings.Builder, d *topicDef) {
+	fmt.Fprintf(b, `<tt:MessageDescription IsProperty="%t"><tt:Source>`, d.IsProperty)
+	for _, it := range d.Source {
+		fmt.Fprintf(b, `<tt:SimpleItemDescription Name="%s" Type="%s"/>`, it.Name, it.Type)
+	}
+	b.WriteString(`</tt:Source><tt:Data>`)
+	for _, it := range d.Data {
+		fmt.Fprintf(b, `<tt:SimpleItemDescription Name="%s" Type="%s"/>`, it.Name, it.Type)
+	}
+	b.WriteString(`</tt:Data></tt:MessageDescription>`)
+}
@@ -2,20 +2,25 @@ package onvif
 
 import (
 	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type Message struct {
 	XMLName           xml.Name `xml:"http://www.onvif.org/ver10/schema Message"`
+	Topic             string   `xml:"-"` // топик ConcreteSet, например TopicMetalDetect
+	DeviceID          string   `xml:"-"` // UID устройства-источника; "" — не привязано к устройству
 	UtcTime           string   `xml:"UtcTime,attr"`
-	PropertyOperation string   `xml:"PropertyOperation,attr"`
+	PropertyOperation string   `xml:"PropertyOperation,attr,omitempty"` // проставляет EventService.Publish
 	Source            Source   `xml:"Source"`
 	Key               Key      `xml:"Key"`
 	Data              Data     `xml:"Data"`
 }
 
 type Source struct {
-	SimpleItem SimpleItem `xml:"SimpleItem"`
+	SimpleItems []SimpleItem `xml:"SimpleItem"`
 }
 
 type Key struct{}
@@ -60,19 +65,140 @@ type Reference struct {
 	Address string   `xml:"Address"`
 }
 
-func NewMetalDetectorMessage(deviceID, image, account string) *Message {
+func messageTime() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05.0000000Z")
+}
+
+// Detection — срабатывание детектора металла (tmk:MetalDetector/tmk:Detect).
+type Detection struct {
+	Image   string // картинка зон, PNG в base64
+	Account string
+	// Результат классификации; Class передаётся как Category.
+	Type, Class, Object uint32
+	AlarmsIn            uint32
+	AlarmsOut           uint32
+	// Zones — строки по уровням катушек снизу вверх, в строке — стороны.
+	Zones [][]ZoneMeasure
+}
+
+// ZoneMeasure — уровень сигнала и счётчик срабатываний зоны.
+type ZoneMeasure struct {
+	Level uint8
+	Cnt   uint32
+}
+
+// Mesures формирует значение SimpleItem "Mesures" — пары key=value
+// через ";":
+//
+//	v=1;class=<type>/<class>/<object>;alarms_in=<n>;alarms_out=<n>;level=<зоны>;cnt=<зоны>
+//
+// <зоны> — строки сетки снизу вверх через ",", стороны в строке через ":".
+// Пример для сетки 2×2: level=10:0,35:12;cnt=1:0,4:2.
+// Новые ключи добавляются в конец; разбор должен пропускать незнакомые.
+func (d Detection) Mesures() string {
+	zones := func(val func(ZoneMeasure) string) string {
+		rows := make([]string, len(d.Zones))
+		for i, row := range d.Zones {
+			cells := make([]string, len(row))
+			for j, z := range row {
+				cells[j] = val(z)
+			}
+			rows[i] = strings.Join(cells, ":")
+		}
+		return strings.Join(rows, ",")
+	}
+	return fmt.Sprintf("v=1;class=%d/%d/%d;alarms_in=%d;alarms_out=%d;level=%s;cnt=%s",
+		d.Type, d.Class, d.Object, d.AlarmsIn, d.AlarmsOut,
+		zones(func(z ZoneMeasure) string { return strconv.Itoa(int(z.Level)) }),
+		zones(func(z ZoneMeasure) string { return strconv.FormatUint(uint64(z.Cnt), 10) }))
+}
+
+func NewMetalDetectorMessage(deviceID string, d Detection) *Message {
 	return &Message{
-		UtcTime:           time.Now().UTC().Format("2006-01-02T15:04:05.0000000Z"),
-		PropertyOperation: "Initialized",
+		Topic:    TopicMetalDetect,
+		DeviceID: deviceID,
+		UtcTime:  messageTime(),
 		Source: Source{
-			SimpleItem: SimpleItem{Name: "Id", Value: deviceID},
+			SimpleItems: []SimpleItem{{Name: "Id", Value: deviceID}},
 		},
 		Key: Key{},
 		Data: Data{
 			SimpleItems: []SimpleItem{
-				{Name: "Picture", Value: image},
-				{Name: "Account", Value: account},
+				{Name: "Picture", Value: d.Image},
+				{Name: "Category", Value: strconv.FormatUint(uint64(d.Class), 10)},
+				{Name: "Mesures", Value: d.Mesures()},
+				{Name: "Account", Value: d.Account},
+			},
+		},
+	}
+}
+
+// AlarmActive — проход классифицирован как металл (Type != 0), тревога
+// активна до следующего чистого прохода.
+func (d Detection) AlarmActive() bool {
+	return d.Type != 0
+}
+
+// NewMetalAlarmMessage — тревога детектора (свойство: активна или нет).
+func NewMetalAlarmMessage(deviceID string, active bool) *Message {
+	return &Message{
+		Topic:    TopicMetalAlarm,
+		DeviceID: deviceID,
+		UtcTime:  messageTime(),
+		Source: Source{
+			SimpleItems: []SimpleItem{{Name: "Id", Value: deviceID}},
+		},
+		Data: Data{
+			SimpleItems: []SimpleItem{{Name: "State", Value: strconv.FormatBool(active)}},
+		},
+	}
+}
+
+// NewPassCounterMessage — счётчики проходов детектора.
+func NewPassCounterMessage(deviceID string, in, out, inside uint32) *Message {
+	return &Message{
+		Topic:    TopicPassCounter,
+		DeviceID: deviceID,
+		UtcTime:  messageTime(),
+		Source: Source{
+			SimpleItems: []SimpleItem{{Name: "Id", Value: deviceID}},
+		},
+		Data: Data{
+			SimpleItems: []SimpleItem{
+				{Name: "In", Value: strconv.FormatUint(uint64(in), 10)},
+				{Name: "Out", Value: strconv.FormatUint(uint64(out), 10)},
+				{Name: "Inside", Value: strconv.FormatUint(uint64(inside), 10)},
 			},
 		},
 	}
 }
+
+// NewDigitalInputMessage — состояние цифрового входа (TTY EVT,<input>,<state>).
+func NewDigitalInputMessage(deviceID, inputToken string, state bool) *Message {
+	return &Message{
+		Topic:    TopicDigitalInput,
+		DeviceID: deviceID,
+		UtcTime:  messageTime(),
+		Source: Source{
+			SimpleItems: []SimpleItem{{Name: "InputToken", Value: inputToken}},
+		},
+		Data: Data{
+			SimpleItems: []SimpleItem{{Name: "LogicalState", Value: strconv.FormatBool(state)}},
+		},
+	}
+}
+
+// NewDeviceStatusMessage — устройство появилось в сети или пропало.
+func NewDeviceStatusMessage(deviceID string, online bool) *Message {
+	return &Message{
+		Topic:    TopicDeviceStatus,
+		DeviceID: deviceID,
+		UtcTime:  messageTime(),
+		Source: Source{
+			SimpleItems: []SimpleItem{{Name: "Id", Value: deviceID}},
+		},
+		Data: Data{
+			SimpleItems: []SimpleItem{{Name: "Online", Value: strconv.FormatBool(online)}},
+		},
+	}
+}
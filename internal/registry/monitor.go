@@ -28,7 +28,7 @@ func (s *Store) checkDevices() {
 
 	for _, dev := range devices {
 		// Пропускаем вшитые устройства - они всегда online
-		if s.isBuiltInDevice(dev.UID) {
+		if IsBuiltIn(dev.UID) {
 			continue
 		}
 
@@ -46,9 +46,12 @@ func (s *Store) checkDevices() {
 	}
 }
 
-// isBuiltInDevice проверяет, является ли устройство вшитым
-func (s *Store) isBuiltInDevice(uid string) bool {
-	builtInDevices := []string{"gate-001", "gate-002", "gate-003", "gate-004"}
+// builtInDevices — вшитые устройства шлюза: всегда online, не опрашиваются
+// монитором и сохраняются в state.json.
+var builtInDevices = []string{"gate-001", "gate-002", "gate-003", "gate-004"}
+
+// IsBuiltIn проверяет, является ли устройство вшитым
+func IsBuiltIn(uid string) bool {
 	for _, builtInUID := range builtInDevices {
 		if uid == builtInUID {
 			return true
@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"slices"
+	"strconv"
 	"sync"
+
+	"github.com/google/uuid"
 )
 
 type Device struct {
@@ -22,13 +26,46 @@ type Device struct {
 	AdapterDS    string `yaml:"adapterDS"    json:"adapter_ds"`
 	Enabled      bool   `yaml:"enabled"      json:"enabled"`
 	Online       bool   `yaml:"-"            json:"online"`
+
+	// Переопределения Device Service; пусто — значения шлюза
+	Hostname string   `yaml:"hostname" json:"hostname,omitempty"`
+	TimeZone string   `yaml:"timezone" json:"timezone,omitempty"` // IANA, например Europe/Moscow
+	DNS      []string `yaml:"dns"      json:"dns,omitempty"`
+	NTP      []string `yaml:"ntp"      json:"ntp,omitempty"`
+
+	// Настраиваемые scope ONVIF (SetScopes/AddScopes/RemoveScopes),
+	// фиксированные — см. FixedScopes
+	Scopes []string `yaml:"scopes" json:"scopes,omitempty"`
+
+	// Адрес WS-Discovery EndpointReference (urn:uuid:…); пусто — выводится из UID
+	EndpointRef string `yaml:"endpoint_ref" json:"endpoint_ref,omitempty"`
+}
+
+// endpointRef — постоянный EndpointReference устройства uid (UUIDv5 от UID):
+// одинаков между перезапусками и для устройств, которых нет в state.json.
+func endpointRef(uid string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("urn:sstmk-onvif:device:"+uid)).URN()
+}
+
+// Change — изменение устройства в реестре. Для нового устройства
+// Added=true и Prev пустой; для удалённого Removed=true и Cur пустой.
+type Change struct {
+	Prev    Device
+	Cur     Device
+	Added   bool
+	Removed bool
 }
 
+// Listener вызывается после изменения реестра (вне блокировки).
+type Listener func(Change)
+
 type Store struct {
 	mu        sync.RWMutex
 	data      map[string]Device
 	usedPorts map[int]bool
 	nextPort  int
+	listeners []Listener
+	deleted   map[string]bool // UID, удалённые через Delete (см. Register)
 }
 
 func NewStore() *Store {
@@ -36,6 +73,7 @@ func NewStore() *Store {
 		data:      map[string]Device{},
 		usedPorts: map[int]bool{},
 		nextPort:  9005,
+		deleted:   map[string]bool{},
 	}
 }
 
@@ -46,22 +84,88 @@ func (s *Store) Get(id string) (Device, bool) {
 	return v, ok
 }
 
-func (s *Store) Upsert(m Device) {
+// OnChange регистрирует слушателя изменений реестра.
+func (s *Store) OnChange(l Listener) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, l)
+}
+
+// notify раздаёт изменение слушателям; вызывать без s.mu.
+func (s *Store) notify(c Change) {
+	s.mu.RLock()
+	listeners := append([]Listener(nil), s.listeners...)
+	s.mu.RUnlock()
+	for _, l := range listeners {
+		l(c)
+	}
+}
+
+func (s *Store) Upsert(m Device) {
+	s.upsert(m, false)
+}
+
+// Register — регистрация устройства адаптером. В отличие от Upsert не трогает
+// enabled известного устройства: его задаёт оператор. Устройство, удалённое
+// через Delete, при новой регистрации возвращается выключенным — оно снова
+// видно в веб-интерфейсе, но не в WS-Discovery, пока его не включат.
+func (s *Store) Register(m Device) {
+	s.upsert(m, true)
+}
+
+func (s *Store) upsert(m Device, register bool) {
+	s.mu.Lock()
 
 	// Если устройство уже есть, обновляем и сохраняем порт
 	if existing, ok := s.data[m.UID]; ok {
 		m.Port = existing.Port // Сохраняем старый порт
+		if register {
+			m.Enabled = existing.Enabled
+		}
+		// Переопределения задаются вручную — повторная регистрация их не сбрасывает;
+		// задать и сбросить их можно только через SetOverrides
+		if m.Hostname == "" {
+			m.Hostname = existing.Hostname
+		}
+		if m.TimeZone == "" {
+			m.TimeZone = existing.TimeZone
+		}
+		if m.DNS == nil {
+			m.DNS = existing.DNS
+		}
+		if m.NTP == nil {
+			m.NTP = existing.NTP
+		}
+		if m.Scopes == nil {
+			m.Scopes = existing.Scopes
+		}
+		if m.EndpointRef == "" {
+			m.EndpointRef = existing.EndpointRef
+		}
 		s.data[m.UID] = m
+		s.mu.Unlock()
+		s.notify(Change{Prev: existing, Cur: m})
 		return
 	}
 
-	// Новое устройство - назначаем порт
+	// Новое устройство - назначаем порт; порт из state.json занимаем,
+	// чтобы allocatePort не выдал его следующему устройству
 	if m.Port == "" {
 		m.Port = fmt.Sprintf("%d", s.allocatePort())
+	} else if n, err := strconv.Atoi(m.Port); err == nil {
+		s.usedPorts[n] = true
+	}
+	if m.EndpointRef == "" {
+		m.EndpointRef = endpointRef(m.UID)
 	}
+	if register && s.deleted[m.UID] {
+		log.Printf("[Registry] Deleted device %s registered again, keeping it disabled", m.UID)
+		m.Enabled = false
+	}
+	delete(s.deleted, m.UID)
 	s.data[m.UID] = m
+	s.mu.Unlock()
+	s.notify(Change{Cur: m, Added: true})
 }
 
 // allocatePort выделяет свободный порт в диапазоне 9005-9230
@@ -81,24 +185,131 @@ func (s *Store) Update(m Device) {
 
 func (s *Store) SetOnline(id string, online bool) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	v, ok := s.data[id]
-	if !ok {
+	if !ok || v.Online == online {
+		s.mu.Unlock()
 		return
 	}
+	prev := v
 	v.Online = online
 	s.data[id] = v
+	s.mu.Unlock()
+	s.notify(Change{Prev: prev, Cur: v})
 }
 
 func (s *Store) SetEnabled(id string, enabled bool) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	v, ok := s.data[id]
-	if !ok {
+	if !ok || v.Enabled == enabled {
+		s.mu.Unlock()
 		return
 	}
+	prev := v
 	v.Enabled = enabled
 	s.data[id] = v
+	s.mu.Unlock()
+	s.notify(Change{Prev: prev, Cur: v})
+}
+
+// SetOverrides задаёт переопределения Device Service как есть: пустое
+// значение возвращает значение шлюза.
+func (s *Store) SetOverrides(id, hostname, timezone string, dns, ntp []string) error {
+	if len(dns) == 0 {
+		dns = nil
+	}
+	if len(ntp) == 0 {
+		ntp = nil
+	}
+	s.mu.Lock()
+	v, ok := s.data[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("device %s not found", id)
+	}
+	if v.Hostname == hostname && v.TimeZone == timezone && slices.Equal(v.DNS, dns) && slices.Equal(v.NTP, ntp) {
+		s.mu.Unlock()
+		return nil
+	}
+	prev := v
+	v.Hostname, v.TimeZone, v.DNS, v.NTP = hostname, timezone, dns, ntp
+	s.data[id] = v
+	s.mu.Unlock()
+	s.notify(Change{Prev: prev, Cur: v})
+	return nil
+}
+
+// SetPort переносит устройство на другой порт. Порт, занятый другим
+// устройством, — ошибка.
+func (s *Store) SetPort(id, port string) error {
+	s.mu.Lock()
+	v, ok := s.data[id]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("device %s not found", id)
+	}
+	if v.Port == port {
+		s.mu.Unlock()
+		return nil
+	}
+	for uid, d := range s.data {
+		if uid != id && d.Port == port {
+			s.mu.Unlock()
+			return fmt.Errorf("port %s is used by %s", port, uid)
+		}
+	}
+	if n, err := strconv.Atoi(v.Port); err == nil {
+		delete(s.usedPorts, n)
+	}
+	if n, err := strconv.Atoi(port); err == nil {
+		s.usedPorts[n] = true
+	}
+	prev := v
+	v.Port = port
+	s.data[id] = v
+	s.mu.Unlock()
+	s.notify(Change{Prev: prev, Cur: v})
+	return nil
+}
+
+// Delete удаляет устройство и освобождает его порт.
+func (s *Store) Delete(id string) bool {
+	s.mu.Lock()
+	v, ok := s.data[id]
+	if !ok {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.data, id)
+	s.deleted[id] = true
+	if port, err := strconv.Atoi(v.Port); err == nil {
+		delete(s.usedPorts, port)
+	}
+	s.mu.Unlock()
+	s.notify(Change{Prev: v, Removed: true})
+	return true
+}
+
+// Deleted возвращает UID удалённых устройств (для state.json).
+func (s *Store) Deleted() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]string, 0, len(s.deleted))
+	for uid := range s.deleted {
+		out = append(out, uid)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// SetDeleted восстанавливает список удалённых устройств из state.json.
+func (s *Store) SetDeleted(uids []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, uid := range uids {
+		if _, ok := s.data[uid]; !ok {
+			s.deleted[uid] = true
+		}
+	}
 }
 
 func (s *Store) List() []Device {
@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"slices"
+	"testing"
+)
+
+// Устройство из state.json приходит с уже назначенным портом; следующее
+// обнаруженное устройство не должно получить тот же порт.
+func TestUpsertReservesPersistedPort(t *testing.T) {
+	s := NewStore()
+	s.Upsert(Device{UID: "det-a", Port: "9005"})
+	s.Upsert(Device{UID: "det-b", Port: "9007"})
+
+	s.Upsert(Device{UID: "det-new-1"})
+	s.Upsert(Device{UID: "det-new-2"})
+
+	want := map[string]string{"det-a": "9005", "det-b": "9007", "det-new-1": "9006", "det-new-2": "9008"}
+	for uid, port := range want {
+		d, ok := s.Get(uid)
+		if !ok || d.Port != port {
+			t.Errorf("%s: port %q, want %s", uid, d.Port, port)
+		}
+	}
+}
+
+// Освобождённый порт выдаётся снова.
+func TestDeleteReleasesPort(t *testing.T) {
+	s := NewStore()
+	s.Upsert(Device{UID: "det-a", Port: "9005"})
+	s.Delete("det-a")
+	s.Upsert(Device{UID: "det-b"})
+	if d, _ := s.Get("det-b"); d.Port != "9005" {
+		t.Errorf("port %q, want 9005", d.Port)
+	}
+}
+
+// Повторная регистрация адаптером не включает устройство, выключенное оператором.
+func TestRegisterKeepsEnabled(t *testing.T) {
+	s := NewStore()
+	s.Register(Device{UID: "det-a", Enabled: true, Online: true})
+	s.SetEnabled("det-a", false)
+
+	s.Register(Device{UID: "det-a", Enabled: true, Online: true})
+	if d, _ := s.Get("det-a"); d.Enabled {
+		t.Error("re-registration enabled a disabled device")
+	}
+}
+
+// Удалённое устройство после регистрации возвращается выключенным,
+// в том числе после перезапуска (список из state.json).
+func TestRegisterDeleted(t *testing.T) {
+	cases := map[string]func(*Store) *Store{
+		"same store": func(s *Store) *Store { return s },
+		"restored": func(s *Store) *Store {
+			restored := NewStore()
+			restored.SetDeleted(s.Deleted())
+			return restored
+		},
+	}
+	for name, restart := range cases {
+		t.Run(name, func(t *testing.T) {
+			s := NewStore()
+			s.Register(Device{UID: "det-a", Enabled: true})
+			s.Delete("det-a")
+			s = restart(s)
+
+			s.Register(Device{UID: "det-a", Enabled: true})
+			d, ok := s.Get("det-a")
+			if !ok || d.Enabled {
+				t.Fatalf("got %+v, %v; want disabled device", d, ok)
+			}
+			if got := s.Deleted(); len(got) != 0 {
+				t.Errorf("Deleted() = %v after re-registration", got)
+			}
+
+			// включённое оператором снова ведёт себя как обычное
+			s.SetEnabled("det-a", true)
+			s.Register(Device{UID: "det-a", Enabled: true})
+			if d, _ := s.Get("det-a"); !d.Enabled {
+				t.Error("enabled device disabled by re-registration")
+			}
+		})
+	}
+}
+
+// Прежний scope type/<модель> отдаётся вместе с новыми на переходный период.
+func TestFixedScopesKeepLegacyType(t *testing.T) {
+	d := Device{Name: "Gate 1", Model: "BM-611", Object: "Hall"}
+	want := []string{
+		"onvif://www.onvif.org/type/NetworkVideoTransmitter",
+		"onvif://www.onvif.org/name/Gate%201",
+		"onvif://www.onvif.org/hardware/BM-611",
+		"onvif://www.onvif.org/location/Hall",
+		"onvif://www.onvif.org/type/BM-611",
+	}
+	if got := d.FixedScopes(); !slices.Equal(got, want) {
+		t.Errorf("FixedScopes() = %v, want %v", got, want)
+	}
+}
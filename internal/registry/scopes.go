@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const scopePrefix = "onvif://www.onvif.org/"
+
+// maxScopes — предел настраиваемых scope устройства.
+const maxScopes = 32
+
+var (
+	ErrNoDevice      = errors.New("registry: device not found")
+	ErrFixedScope    = errors.New("registry: scope is fixed")
+	ErrNoScope       = errors.New("registry: scope not found")
+	ErrTooManyScopes = errors.New("registry: too many scopes")
+	ErrInvalidScope  = errors.New("registry: invalid scope")
+)
+
+// FixedScopes — scope, вычисляемые из полей устройства; через ONVIF
+// их не изменить (ScopeDef=Fixed).
+func (d Device) FixedScopes() []string {
+	var out []string
+	add := func(kind, v string) {
+		if v != "" {
+			out = append(out, scopePrefix+kind+"/"+url.PathEscape(v))
+		}
+	}
+	add("type", "NetworkVideoTransmitter")
+	add("name", d.Name)
+	add("hardware", d.Model)
+	add("location", d.Object)
+	// Прежний scope типа (type/<модель>) — на переходный период, чтобы не
+	// сломать VMS, которые ищут устройства по нему; модель — в hardware/
+	add("type", d.Model)
+	return out
+}
+
+// AllScopes — фиксированные и настраиваемые scope: их отдают GetScopes
+// и ProbeMatch.
+func (d Device) AllScopes() []string {
+	out := d.FixedScopes()
+	for _, s := range d.Scopes {
+		if !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func validScope(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && !strings.ContainsAny(s, " \t\r\n")
+}
+
+// updateScopes меняет настраиваемые scope устройства id через fn.
+func (s *Store) updateScopes(id string, fn func(d Device) ([]string, error)) error {
+	s.mu.Lock()
+	v, ok := s.data[id]
+	if !ok {
+		s.mu.Unlock()
+		return ErrNoDevice
+	}
+	scopes, err := fn(v)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if len(scopes) > maxScopes {
+		s.mu.Unlock()
+		return ErrTooManyScopes
+	}
+	prev := v
+	v.Scopes = scopes
+	s.data[id] = v
+	s.mu.Unlock()
+	s.notify(Change{Prev: prev, Cur: v})
+	return nil
+}
+
+// SetScopes заменяет настраиваемые scope устройства.
+func (s *Store) SetScopes(id string, scopes []string) error {
+	return s.updateScopes(id, func(d Device) ([]string, error) {
+		fixed := d.FixedScopes()
+		out := []string{}
+		for _, sc := range scopes {
+			if !validScope(sc) {
+				return nil, ErrInvalidScope
+			}
+			if slices.Contains(fixed, sc) {
+				return nil, ErrFixedScope
+			}
+			if !slices.Contains(out, sc) {
+				out = append(out, sc)
+			}
+		}
+		return out, nil
+	})
+}
+
+// AddScopes добавляет настраиваемые scope; уже существующие пропускаются.
+func (s *Store) AddScopes(id string, scopes []string) error {
+	return s.updateScopes(id, func(d Device) ([]string, error) {
+		all := d.AllScopes()
+		out := slices.Clone(d.Scopes)
+		for _, sc := range scopes {
+			if !validScope(sc) {
+				return nil, ErrInvalidScope
+			}
+			if !slices.Contains(all, sc) {
+				all = append(all, sc)
+				out = append(out, sc)
+			}
+		}
+		return out, nil
+	})
+}
+
+// RemoveScopes удаляет настраиваемые scope. Фиксированный scope —
+// ErrFixedScope, отсутствующий — ErrNoScope; тогда ничего не меняется.
+func (s *Store) RemoveScopes(id string, scopes []string) error {
+	return s.updateScopes(id, func(d Device) ([]string, error) {
+		fixed := d.FixedScopes()
+		out := slices.Clone(d.Scopes)
+		for _, sc := range scopes {
+			if slices.Contains(fixed, sc) {
+				return nil, ErrFixedScope
+			}
+			i := slices.Index(out, sc)
+			if i < 0 {
+				return nil, ErrNoScope
+			}
+			out = slices.Delete(out, i, i+1)
+		}
+		if out == nil {
+			out = []string{}
+		}
+		return out, nil
+	})
+}
@@ -0,0 +1,41 @@
+package snapshot
+
+import (
+	"sync"
+	"time"
+)
+
+// Image — последний снимок устройства (PNG).
+type Image struct {
+	PNG  []byte
+	Time time.Time
+}
+
+// Store хранит последний снимок зон по каждому устройству.
+type Store struct {
+	mu     sync.RWMutex
+	images map[string]Image
+}
+
+func NewStore() *Store {
+	return &Store{images: map[string]Image{}}
+}
+
+// Put заменяет снимок устройства deviceID.
+func (s *Store) Put(deviceID string, png []byte, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[deviceID] = Image{PNG: png, Time: t}
+}
+
+// Get — последний снимок устройства; false, если событий ещё не было.
+// nil-Store пуст.
+func (s *Store) Get(deviceID string) (Image, bool) {
+	if s == nil {
+		return Image{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	img, ok := s.images[deviceID]
+	return img, ok
+}
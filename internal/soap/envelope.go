@@ -0,0 +1,249 @@
+package soap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Пространства имён SOAP и WS-Addressing, которые встречаются у клиентов ONVIF.
+const (
+	NamespaceSOAP12         = "http://www.w3.org/2003/05/soap-envelope"
+	NamespaceSOAP11         = "http://schemas.xmlsoap.org/soap/envelope/"
+	NamespaceAddressing     = "http://www.w3.org/2005/08/addressing"
+	NamespaceAddressing2004 = "http://schemas.xmlsoap.org/ws/2004/08/addressing"
+)
+
+var (
+	ErrNotEnvelope = errors.New("soap: root element is not Envelope")
+	ErrNoBody      = errors.New("soap: Body is missing or empty")
+)
+
+// Envelope — разобранный SOAP-запрос: адресные заголовки WS-Addressing
+// и квалифицированное имя операции (первый дочерний элемент Body).
+// Сами данные операции декодируются по требованию через DecodeBody.
+type Envelope struct {
+	Action    string
+	MessageID string
+	ReplyTo   string
+	To        string
+
+	// Operation — имя первого элемента внутри Body с учётом namespace,
+	// например {http://www.onvif.org/ver10/events/wsdl PullMessages}.
+	Operation xml.Name
+
+	// UsernameToken из wsse:Security; nil, если клиент его не прислал.
+	UsernameToken *UsernameToken
+
+	headers map[xml.Name]string // текст прочих блоков Header (reference parameters и т.п.)
+	raw     []byte
+}
+
+// Parse разбирает SOAP 1.1/1.2 конверт. Префиксы не важны —
+// сравнение идёт только по namespace URI и локальному имени.
+func Parse(b []byte) (*Envelope, error) {
+	env := &Envelope{headers: map[xml.Name]string{}, raw: b}
+	d := xml.NewDecoder(bytes.NewReader(b))
+
+	root, err := nextStart(d)
+	if err != nil {
+		return nil, fmt.Errorf("soap: %w", err)
+	}
+	if !isEnvelopeNS(root.Name.Space) || root.Name.Local != "Envelope" {
+		return nil, ErrNotEnvelope
+	}
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("soap: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case isEnvelopeNS(t.Name.Space) && t.Name.Local == "Header":
+				if err := env.parseHeader(d); err != nil {
+					return nil, err
+				}
+			case isEnvelopeNS(t.Name.Space) && t.Name.Local == "Body":
+				op, err := nextStart(d)
+				if err != nil {
+					return nil, ErrNoBody
+				}
+				env.Operation = op.Name
+				return env, nil
+			default:
+				if err := d.Skip(); err != nil {
+					return nil, fmt.Errorf("soap: %w", err)
+				}
+			}
+		case xml.EndElement:
+			// дошли до </Envelope>, а Body так и не встретился
+			return nil, ErrNoBody
+		}
+	}
+}
+
+func (env *Envelope) parseHeader(d *xml.Decoder) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return fmt.Errorf("soap: header: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if isSecurity(t.Name) {
+				var sec securityHeader
+				if err := d.DecodeElement(&sec, &t); err != nil {
+					return fmt.Errorf("soap: header %s: %w", t.Name.Local, err)
+				}
+				env.UsernameToken = sec.UsernameToken
+				continue
+			}
+			var v struct {
+				Text    string `xml:",chardata"`
+				Address string `xml:"Address"`
+			}
+			if err := d.DecodeElement(&v, &t); err != nil {
+				return fmt.Errorf("soap: header %s: %w", t.Name.Local, err)
+			}
+			if !isAddressingNS(t.Name.Space) {
+				env.headers[t.Name] = strings.TrimSpace(v.Text)
+				continue
+			}
+			switch t.Name.Local {
+			case "Action":
+				env.Action = strings.TrimSpace(v.Text)
+			case "MessageID":
+				env.MessageID = strings.TrimSpace(v.Text)
+			case "To":
+				env.To = strings.TrimSpace(v.Text)
+			case "ReplyTo":
+				env.ReplyTo = strings.TrimSpace(v.Address)
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// HeaderValue возвращает текст блока Header {space}local, например
+// reference parameter, который клиент вернул из EndpointReference.
+func (env *Envelope) HeaderValue(space, local string) string {
+	return env.headers[xml.Name{Space: space, Local: local}]
+}
+
+// DecodeBody декодирует элемент операции из Body в v (как xml.Unmarshal).
+// Конверт перечитывается целиком, чтобы объявления namespace из
+// Envelope/Body оставались в силе.
+func (env *Envelope) DecodeBody(v any) error {
+	d := xml.NewDecoder(bytes.NewReader(env.raw))
+	inBody := false
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return ErrNoBody
+			}
+			return fmt.Errorf("soap: %w", err)
+		}
+		t, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if inBody {
+			return d.DecodeElement(v, &t)
+		}
+		if isEnvelopeNS(t.Name.Space) && t.Name.Local == "Body" {
+			inBody = true
+		}
+	}
+}
+
+// PrefixesInScope возвращает объявления xmlns:prefix, действующие на
+// первом элементе Body с локальным именем local (как тег `xml:"local"`),
+// включая объявленные на нём самом. Нужно для выражений, где префиксы
+// встречаются в тексте (TopicExpression, XPath в MessageContent): префикс
+// значит то, что объявлено в его области видимости, а не где-то ещё в
+// конверте. Вложенное объявление перекрывает внешнее. Элемента нет — nil.
+func (env *Envelope) PrefixesInScope(local string) map[string]string {
+	d := xml.NewDecoder(bytes.NewReader(env.raw))
+	scope := map[string]string{}
+	var stack []map[string]string // области видимости предков
+	inBody := false
+	for {
+		tok, err := d.RawToken()
+		if err != nil {
+			return nil
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, scope)
+			scope = declare(scope, t.Attr)
+			if inBody && t.Name.Local == local {
+				return scope
+			}
+			// Envelope/Body: Body — на втором уровне
+			if len(stack) == 2 && t.Name.Local == "Body" {
+				inBody = true
+			}
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil
+			}
+			scope = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 1 {
+				inBody = false
+			}
+		}
+	}
+}
+
+// declare добавляет к области видимости объявления xmlns:prefix элемента.
+// Родительская карта не меняется: она нужна после закрытия элемента.
+func declare(scope map[string]string, attrs []xml.Attr) map[string]string {
+	var out map[string]string
+	for _, a := range attrs {
+		if a.Name.Space != "xmlns" {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string, len(scope)+1)
+			for k, v := range scope {
+				out[k] = v
+			}
+		}
+		out[a.Name.Local] = a.Value
+	}
+	if out == nil {
+		return scope
+	}
+	return out
+}
+
+func nextStart(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return t, nil
+		case xml.EndElement:
+			return xml.StartElement{}, io.ErrUnexpectedEOF
+		}
+	}
+}
+
+func isEnvelopeNS(ns string) bool {
+	return ns == NamespaceSOAP12 || ns == NamespaceSOAP11
+}
+
+func isAddressingNS(ns string) bool {
+	return ns == NamespaceAddressing || ns == NamespaceAddressing2004
+}
@@ -0,0 +1,203 @@
+package soap
+
+import (
+	"encoding/xml"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	const nsEvents = "http://www.onvif.org/ver10/events/wsdl"
+	pull := xml.Name{Space: nsEvents, Local: "PullMessages"}
+
+	// поля Envelope, которые проверяет тест
+	type parsed struct {
+		Action, MessageID, ReplyTo, To string
+		Operation                      xml.Name
+	}
+	tests := []struct {
+		name string
+		raw  string
+		want parsed
+		err  error
+	}{
+		{
+			name: "SOAP 1.2, WS-Addressing 2005/08",
+			raw: `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://www.w3.org/2005/08/addressing">` +
+				`<s:Header><a:Action>urn:act</a:Action><a:MessageID> urn:uuid:1 </a:MessageID>` +
+				`<a:ReplyTo><a:Address>http://client/</a:Address></a:ReplyTo><a:To>http://dev/onvif</a:To></s:Header>` +
+				`<s:Body><tev:PullMessages xmlns:tev="http://www.onvif.org/ver10/events/wsdl"/></s:Body></s:Envelope>`,
+			want: parsed{Action: "urn:act", MessageID: "urn:uuid:1", ReplyTo: "http://client/", To: "http://dev/onvif", Operation: pull},
+		},
+		{
+			name: "SOAP 1.1, WS-Addressing 2004/08, other prefixes",
+			raw: `<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/">` +
+				`<soapenv:Header><w:MessageID xmlns:w="http://schemas.xmlsoap.org/ws/2004/08/addressing">m1</w:MessageID></soapenv:Header>` +
+				`<soapenv:Body><x:PullMessages xmlns:x="http://www.onvif.org/ver10/events/wsdl"/></soapenv:Body></soapenv:Envelope>`,
+			want: parsed{MessageID: "m1", Operation: pull},
+		},
+		{
+			name: "default namespaces, no Header",
+			raw: `<Envelope xmlns="http://www.w3.org/2003/05/soap-envelope"><Body>` +
+				`<PullMessages xmlns="http://www.onvif.org/ver10/events/wsdl"/></Body></Envelope>`,
+			want: parsed{Operation: pull},
+		},
+		{
+			name: "prefix rebound on Body",
+			raw: `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:t="urn:outer">` +
+				`<s:Body xmlns:t="http://www.onvif.org/ver10/events/wsdl"><t:PullMessages/></s:Body></s:Envelope>`,
+			want: parsed{Operation: pull},
+		},
+		{
+			name: "Action from a foreign namespace",
+			raw: `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">` +
+				`<s:Header><x:Action xmlns:x="urn:x">urn:act</x:Action></s:Header>` +
+				`<s:Body><t:Op xmlns:t="urn:t"/></s:Body></s:Envelope>`,
+			want: parsed{Operation: xml.Name{Space: "urn:t", Local: "Op"}},
+		},
+		{
+			name: "elements before Body",
+			raw: `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><x:Extra xmlns:x="urn:x"><x:Body/></x:Extra>` +
+				`<s:Body><t:Op xmlns:t="urn:t"/></s:Body></s:Envelope>`,
+			want: parsed{Operation: xml.Name{Space: "urn:t", Local: "Op"}},
+		},
+		{
+			name: "unknown SOAP version",
+			raw:  `<s:Envelope xmlns:s="urn:soap"><s:Body><t:Op xmlns:t="urn:t"/></s:Body></s:Envelope>`,
+			err:  ErrNotEnvelope,
+		},
+		{
+			name: "root is not Envelope",
+			raw:  `<s:Body xmlns:s="http://www.w3.org/2003/05/soap-envelope"/>`,
+			err:  ErrNotEnvelope,
+		},
+		{
+			name: "Body of another namespace",
+			raw:  `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><x:Body xmlns:x="urn:x"><t:Op xmlns:t="urn:t"/></x:Body></s:Envelope>`,
+			err:  ErrNoBody,
+		},
+		{
+			name: "empty Body",
+			raw:  `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body></s:Body></s:Envelope>`,
+			err:  ErrNoBody,
+		},
+		{
+			name: "no Body",
+			raw:  `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Header/></s:Envelope>`,
+			err:  ErrNoBody,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := Parse([]byte(tt.raw))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Parse: %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			got := parsed{Action: env.Action, MessageID: env.MessageID, ReplyTo: env.ReplyTo, To: env.To, Operation: env.Operation}
+			if got != tt.want {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, raw := range []string{
+		``,
+		`not xml`,
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Header>`,
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Header><a:To xmlns:a="http://www.w3.org/2005/08/addressing"></s:Header></s:Envelope>`,
+	} {
+		if env, err := Parse([]byte(raw)); err == nil {
+			t.Errorf("Parse(%q) = %+v, want error", raw, env)
+		}
+	}
+}
+
+// DecodeBody видит объявления namespace, сделанные на Envelope и Body.
+func TestDecodeBody(t *testing.T) {
+	raw := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:tev="http://www.onvif.org/ver10/events/wsdl">` +
+		`<s:Body><tev:PullMessages><tev:Timeout>PT5S</tev:Timeout><tev:MessageLimit>7</tev:MessageLimit></tev:PullMessages></s:Body></s:Envelope>`
+	env, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req struct {
+		XMLName      xml.Name `xml:"http://www.onvif.org/ver10/events/wsdl PullMessages"`
+		Timeout      string   `xml:"http://www.onvif.org/ver10/events/wsdl Timeout"`
+		MessageLimit int      `xml:"http://www.onvif.org/ver10/events/wsdl MessageLimit"`
+	}
+	if err := env.DecodeBody(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Timeout != "PT5S" || req.MessageLimit != 7 {
+		t.Errorf("DecodeBody = %+v", req)
+	}
+}
+
+func TestHeaderValue(t *testing.T) {
+	raw := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">` +
+		`<s:Header><sst:SubscriptionId xmlns:sst="urn:sst"> 42 </sst:SubscriptionId></s:Header>` +
+		`<s:Body><t:Op xmlns:t="urn:t"/></s:Body></s:Envelope>`
+	env, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := env.HeaderValue("urn:sst", "SubscriptionId"); got != "42" {
+		t.Errorf("HeaderValue = %q, want 42", got)
+	}
+	if got := env.HeaderValue("urn:other", "SubscriptionId"); got != "" {
+		t.Errorf("HeaderValue of another namespace = %q, want empty", got)
+	}
+}
+
+func TestParseUsernameToken(t *testing.T) {
+	raw := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">` +
+		`<s:Header><wsse:Security xmlns:wsse="` + NamespaceWSSE + `" xmlns:wsu="` + NamespaceWSU + `"><wsse:UsernameToken>` +
+		`<wsse:Username>admin</wsse:Username><wsse:Password Type="` + PasswordDigest + `">pw</wsse:Password>` +
+		`<wsse:Nonce>bm9uY2U=</wsse:Nonce><wsu:Created>2024-05-01T12:00:00Z</wsu:Created>` +
+		`</wsse:UsernameToken></wsse:Security></s:Header>` +
+		`<s:Body><t:Op xmlns:t="urn:t"/></s:Body></s:Envelope>`
+	env, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := env.UsernameToken
+	if tok == nil {
+		t.Fatal("UsernameToken is nil")
+	}
+	if tok.Username != "admin" || tok.Password.Type != PasswordDigest || tok.Password.Value != "pw" ||
+		tok.Nonce != "bm9uY2U=" || tok.Created != "2024-05-01T12:00:00Z" {
+		t.Errorf("UsernameToken = %+v", tok)
+	}
+}
+
+func TestPrefixesInScope(t *testing.T) {
+	raw := `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="urn:envelope">` +
+		`<s:Header><h:Filter xmlns:h="urn:h" xmlns:b="urn:header"/></s:Header>` +
+		`<s:Body xmlns:b="urn:body">` +
+		`<t:Op xmlns:t="urn:t"><x:Sibling xmlns:x="urn:x" xmlns:c="urn:sibling"/>` +
+		`<t:Filter xmlns:a="urn:filter" xmlns:d="urn:d"><t:Inner xmlns:d="urn:inner"/></t:Filter>` +
+		`</t:Op></s:Body></s:Envelope>`
+	env, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Filter из Header не в счёт; a перекрыт на Filter; c объявлен у соседа
+	filter := map[string]string{"s": NamespaceSOAP12, "a": "urn:filter", "b": "urn:body", "t": "urn:t", "d": "urn:d"}
+	if got := env.PrefixesInScope("Filter"); !reflect.DeepEqual(got, filter) {
+		t.Errorf("Filter: %v\nwant %v", got, filter)
+	}
+	inner := map[string]string{"s": NamespaceSOAP12, "a": "urn:filter", "b": "urn:body", "t": "urn:t", "d": "urn:inner"}
+	if got := env.PrefixesInScope("Inner"); !reflect.DeepEqual(got, inner) {
+		t.Errorf("Inner: %v\nwant %v", got, inner)
+	}
+	if got := env.PrefixesInScope("Missing"); got != nil {
+		t.Errorf("Missing: %v, want nil", got)
+	}
+}
@@ -0,0 +1,166 @@
+package soap
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FaultAction — wsa:Action ответа с ошибкой (WS-Addressing SOAP binding).
+const FaultAction = "http://www.w3.org/2005/08/addressing/soap/fault"
+
+// Коды env:Code/env:Value (SOAP 1.2)
+const (
+	CodeSender          = "env:Sender"
+	CodeReceiver        = "env:Receiver"
+	CodeMustUnderstand  = "env:MustUnderstand"
+	CodeVersionMismatch = "env:VersionMismatch"
+)
+
+// Подкоды ONVIF (ter:) и WS-ResourceFramework/WS-BaseNotification.
+// Префиксы объявлены на env:Fault — см. faultNamespaces.
+const (
+	SubcodeWellFormed          = "ter:WellFormed"
+	SubcodeInvalidArgs         = "ter:InvalidArgs"
+	SubcodeInvalidArgVal       = "ter:InvalidArgVal"
+	SubcodeActionNotSupported  = "ter:ActionNotSupported"
+	SubcodeAction              = "ter:Action"
+	SubcodeNotAuthorized       = "ter:NotAuthorized"
+	SubcodeOperationProhibited = "ter:OperationProhibited"
+	SubcodeResourceUnknown     = "wsrf-rw:ResourceUnknownFault"
+)
+
+// faultNamespaces — префиксы, доступные в подкодах и Detail.
+var faultNamespaces = [][2]string{
+	{"ter", "http://www.onvif.org/ver10/error"},
+	{"wsrf-rw", "http://docs.oasis-open.org/wsrf/rw-2"},
+	{"wsrf-bf", "http://docs.oasis-open.org/wsrf/bf-2"},
+	{"wsnt", "http://docs.oasis-open.org/wsn/b-2"},
+}
+
+// Fault — SOAP 1.2 env:Fault.
+type Fault struct {
+	Code     string   // CodeSender, CodeReceiver, ...
+	Subcodes []string // вложенные env:Subcode, от общего к частному
+	Reason   string   // env:Reason/env:Text (en)
+	Detail   string   // готовый XML внутри env:Detail; "" — без Detail
+	Status   int      // HTTP-статус; 0 — по Code (Sender → 400, иначе 500)
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("soap fault %s %s: %s", f.Code, strings.Join(f.Subcodes, "/"), f.Reason)
+}
+
+// HTTPStatus — статус ответа по привязке SOAP 1.2 к HTTP.
+func (f *Fault) HTTPStatus() int {
+	switch {
+	case f.Status != 0:
+		return f.Status
+	case f.Code == CodeSender:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// WellFormed — запрос не является корректным SOAP-конвертом.
+func WellFormed(reason string) *Fault {
+	return &Fault{Code: CodeSender, Subcodes: []string{SubcodeWellFormed}, Reason: reason}
+}
+
+// InvalidArgVal — аргумент операции имеет недопустимое значение.
+func InvalidArgVal(reason string) *Fault {
+	return &Fault{Code: CodeSender, Subcodes: []string{SubcodeInvalidArgVal}, Reason: reason}
+}
+
+// InvalidArgs — тело операции не удалось разобрать.
+func InvalidArgs(reason string) *Fault {
+	return &Fault{Code: CodeSender, Subcodes: []string{SubcodeInvalidArgs}, Reason: reason}
+}
+
+// ActionNotSupported — операция не реализована сервисом.
+func ActionNotSupported(op string) *Fault {
+	return &Fault{
+		Code:     CodeReceiver,
+		Subcodes: []string{SubcodeActionNotSupported},
+		Reason:   fmt.Sprintf("Optional Action %s Not Implemented", op),
+	}
+}
+
+// Action — операция поддерживается, но выполнить её сейчас не удалось.
+func Action(reason string) *Fault {
+	return &Fault{Code: CodeReceiver, Subcodes: []string{SubcodeAction}, Reason: reason}
+}
+
+// NotAuthorized — учётные данные отсутствуют или неверны.
+func NotAuthorized() *Fault {
+	return &Fault{Code: CodeSender, Subcodes: []string{SubcodeNotAuthorized}, Reason: "Sender not Authorized"}
+}
+
+// ResourceUnknown — wsrf-rw:ResourceUnknownFault (неизвестная подписка и т.п.).
+func ResourceUnknown(reason string) *Fault {
+	return &Fault{
+		Code:     CodeSender,
+		Subcodes: []string{SubcodeResourceUnknown},
+		Reason:   reason,
+		Detail:   BaseFaultDetail("wsrf-rw:ResourceUnknownFault", ""),
+	}
+}
+
+// BaseFaultDetail формирует элемент Detail в стиле WS-BaseFaults:
+// <elem><wsrf-bf:Timestamp/>extra</elem>.
+func BaseFaultDetail(elem, extra string) string {
+	return fmt.Sprintf(`<%s><wsrf-bf:Timestamp>%s</wsrf-bf:Timestamp>%s</%s>`,
+		elem, time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), extra, elem)
+}
+
+// XML — элемент env:Fault.
+func (f *Fault) XML() string {
+	var b strings.Builder
+	b.WriteString(`<env:Fault`)
+	for _, ns := range faultNamespaces {
+		fmt.Fprintf(&b, ` xmlns:%s="%s"`, ns[0], ns[1])
+	}
+	fmt.Fprintf(&b, `><env:Code><env:Value>%s</env:Value>`, f.Code)
+	for _, sc := range f.Subcodes {
+		fmt.Fprintf(&b, `<env:Subcode><env:Value>%s</env:Value>`, sc)
+	}
+	for range f.Subcodes {
+		b.WriteString(`</env:Subcode>`)
+	}
+	b.WriteString(`</env:Code><env:Reason><env:Text xml:lang="en">`)
+	_ = xml.EscapeText(&b, []byte(f.Reason))
+	b.WriteString(`</env:Text></env:Reason>`)
+	if f.Detail != "" {
+		b.WriteString(`<env:Detail>` + f.Detail + `</env:Detail>`)
+	}
+	b.WriteString(`</env:Fault>`)
+	return b.String()
+}
+
+// WriteFault отвечает конвертом SOAP 1.2 с env:Fault. req — разобранный
+// запрос (для wsa:RelatesTo); nil, если конверт разобрать не удалось.
+func WriteFault(w http.ResponseWriter, req *Envelope, f *Fault) {
+	var header strings.Builder
+	fmt.Fprintf(&header, `<wsa:Action>%s</wsa:Action><wsa:MessageID>uuid:%s</wsa:MessageID>`,
+		FaultAction, uuid.New().String())
+	if req != nil && req.MessageID != "" {
+		// некоторые клиенты переносят MessageID по строкам
+		header.WriteString(`<wsa:RelatesTo>`)
+		_ = xml.EscapeText(&header, []byte(strings.Join(strings.Fields(req.MessageID), "")))
+		header.WriteString(`</wsa:RelatesTo>`)
+	}
+
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	w.WriteHeader(f.HTTPStatus())
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<env:Envelope xmlns:env="%s" xmlns:wsa="%s">`+
+		`<env:Header>%s</env:Header><env:Body>%s</env:Body></env:Envelope>`,
+		NamespaceSOAP12, NamespaceAddressing, header.String(), f.XML())
+	log.Printf("soap: fault %d %s", f.HTTPStatus(), f.Error())
+}
@@ -0,0 +1,171 @@
+package soap
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// faultCode — env:Code с вложенными env:Subcode.
+type faultCode struct {
+	Value   string     `xml:"http://www.w3.org/2003/05/soap-envelope Value"`
+	Subcode *faultCode `xml:"http://www.w3.org/2003/05/soap-envelope Subcode"`
+}
+
+type faultXML struct {
+	XMLName xml.Name  `xml:"http://www.w3.org/2003/05/soap-envelope Fault"`
+	Code    faultCode `xml:"http://www.w3.org/2003/05/soap-envelope Code"`
+	Reason  string    `xml:"http://www.w3.org/2003/05/soap-envelope Reason>Text"`
+	Detail  *struct {
+		Inner string `xml:",innerxml"`
+	} `xml:"http://www.w3.org/2003/05/soap-envelope Detail"`
+}
+
+// parseFault разбирает f.XML(). Префикс env объявлен на конверте,
+// поэтому элемент оборачивается в объявление.
+func parseFault(f *Fault) (faultXML, error) {
+	var got struct {
+		Fault faultXML `xml:"http://www.w3.org/2003/05/soap-envelope Fault"`
+	}
+	err := xml.Unmarshal([]byte(`<wrap xmlns:env="`+NamespaceSOAP12+`">`+f.XML()+`</wrap>`), &got)
+	return got.Fault, err
+}
+
+// codes разворачивает цепочку Code/Subcode в список значений.
+func (c faultCode) codes() []string {
+	out := []string{c.Value}
+	for sc := c.Subcode; sc != nil; sc = sc.Subcode {
+		out = append(out, sc.Value)
+	}
+	return out
+}
+
+func TestFaultXML(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault *Fault
+		codes string // Code/Subcode/...
+	}{
+		{"no subcodes", &Fault{Code: CodeReceiver, Reason: "internal"}, "env:Receiver"},
+		{"one subcode", InvalidArgVal("bad value"), "env:Sender/ter:InvalidArgVal"},
+		{
+			"nested subcodes",
+			&Fault{Code: CodeSender, Subcodes: []string{SubcodeInvalidArgVal, "ter:NoConfig"}, Reason: "r"},
+			"env:Sender/ter:InvalidArgVal/ter:NoConfig",
+		},
+		{"action not supported", ActionNotSupported("GetFoo"), "env:Receiver/ter:ActionNotSupported"},
+		{"action failed", Action("device is busy"), "env:Receiver/ter:Action"},
+	}
+	for _, tt := range tests {
+		got, err := parseFault(tt.fault)
+		if err != nil {
+			t.Errorf("%s: XML is not well-formed: %v", tt.name, err)
+			continue
+		}
+		if codes := strings.Join(got.Code.codes(), "/"); codes != tt.codes {
+			t.Errorf("%s: codes %s, want %s", tt.name, codes, tt.codes)
+		}
+		if got.Reason != tt.fault.Reason {
+			t.Errorf("%s: reason %q, want %q", tt.name, got.Reason, tt.fault.Reason)
+		}
+	}
+}
+
+func TestFaultXMLEscapesReason(t *testing.T) {
+	f := InvalidArgs(`<Timeout> & "more"`)
+	got, err := parseFault(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Reason != f.Reason {
+		t.Errorf("reason %q, want %q", got.Reason, f.Reason)
+	}
+	if got.Detail != nil {
+		t.Errorf("unexpected Detail %q", got.Detail.Inner)
+	}
+}
+
+func TestFaultDetail(t *testing.T) {
+	got, err := parseFault(ResourceUnknown("no such subscription"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Detail == nil || !strings.Contains(got.Detail.Inner, "<wsrf-rw:ResourceUnknownFault><wsrf-bf:Timestamp>") {
+		t.Errorf("Detail = %+v", got.Detail)
+	}
+}
+
+func TestFaultHTTPStatus(t *testing.T) {
+	tests := []struct {
+		fault *Fault
+		want  int
+	}{
+		{WellFormed("x"), http.StatusBadRequest},
+		{NotAuthorized(), http.StatusBadRequest},
+		{ActionNotSupported("Op"), http.StatusInternalServerError},
+		{Action("busy"), http.StatusInternalServerError},
+		{&Fault{Code: CodeReceiver}, http.StatusInternalServerError},
+		{&Fault{Code: CodeMustUnderstand}, http.StatusInternalServerError},
+		{&Fault{Code: CodeSender, Status: http.StatusUnauthorized}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := tt.fault.HTTPStatus(); got != tt.want {
+			t.Errorf("%s: HTTPStatus = %d, want %d", tt.fault.Error(), got, tt.want)
+		}
+	}
+}
+
+func TestWriteFault(t *testing.T) {
+	type header struct {
+		Action    string `xml:"http://www.w3.org/2005/08/addressing Action"`
+		MessageID string `xml:"http://www.w3.org/2005/08/addressing MessageID"`
+		RelatesTo string `xml:"http://www.w3.org/2005/08/addressing RelatesTo"`
+	}
+	type envelope struct {
+		Header header   `xml:"http://www.w3.org/2003/05/soap-envelope Header"`
+		Fault  faultXML `xml:"http://www.w3.org/2003/05/soap-envelope Body>Fault"`
+	}
+
+	req, err := Parse([]byte(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://www.w3.org/2005/08/addressing">` +
+		`<s:Header><a:MessageID>urn:uuid:1234
+			-5678</a:MessageID></s:Header><s:Body><t:Op xmlns:t="urn:t"/></s:Body></s:Envelope>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		req       *Envelope
+		relatesTo string
+	}{
+		{req, "urn:uuid:1234-5678"}, // перенос строки внутри MessageID убирается
+		{nil, ""},
+	} {
+		w := httptest.NewRecorder()
+		WriteFault(w, tc.req, InvalidArgVal("bad"))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/soap+xml") {
+			t.Errorf("Content-Type %q", ct)
+		}
+		var env envelope
+		if err := xml.Unmarshal(w.Body.Bytes(), &env); err != nil {
+			t.Fatalf("response is not well-formed: %v\n%s", err, w.Body)
+		}
+		if env.Header.Action != FaultAction {
+			t.Errorf("Action %q, want %q", env.Header.Action, FaultAction)
+		}
+		if !strings.HasPrefix(env.Header.MessageID, "uuid:") {
+			t.Errorf("MessageID %q", env.Header.MessageID)
+		}
+		if env.Header.RelatesTo != tc.relatesTo {
+			t.Errorf("RelatesTo %q, want %q", env.Header.RelatesTo, tc.relatesTo)
+		}
+		if codes := strings.Join(env.Fault.Code.codes(), "/"); codes != "env:Sender/ter:InvalidArgVal" {
+			t.Errorf("codes %s", codes)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package soap
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+// HandlerFunc обрабатывает одну SOAP-операцию.
+type HandlerFunc func(w http.ResponseWriter, r *http.Request, env *Envelope)
+
+// Mux — таблица диспетчеризации по квалифицированному имени элемента Body.
+type Mux struct {
+	handlers map[xml.Name]HandlerFunc
+}
+
+func NewMux() *Mux {
+	return &Mux{handlers: make(map[xml.Name]HandlerFunc)}
+}
+
+// Handle регистрирует обработчик для операции {space}local.
+func (m *Mux) Handle(space, local string, h HandlerFunc) {
+	m.handlers[xml.Name{Space: space, Local: local}] = h
+}
+
+// Lookup возвращает обработчик для операции из конверта.
+func (m *Mux) Lookup(env *Envelope) (HandlerFunc, bool) {
+	h, ok := m.handlers[env.Operation]
+	return h, ok
+}
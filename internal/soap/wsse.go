@@ -0,0 +1,31 @@
+package soap
+
+import "encoding/xml"
+
+// Пространства имён и типы WS-Security (OASIS WSS 1.0).
+const (
+	NamespaceWSSE = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"
+	NamespaceWSU  = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+
+	PasswordDigest = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest"
+	PasswordText   = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordText"
+)
+
+// UsernameToken — wsse:UsernameToken из заголовка wsse:Security.
+type UsernameToken struct {
+	Username string `xml:"Username"`
+	Password struct {
+		Type  string `xml:"Type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"Password"`
+	Nonce   string `xml:"Nonce"`   // base64
+	Created string `xml:"Created"` // wsu:Created, xs:dateTime
+}
+
+type securityHeader struct {
+	UsernameToken *UsernameToken `xml:"UsernameToken"`
+}
+
+func isSecurity(name xml.Name) bool {
+	return name.Space == NamespaceWSSE && name.Local == "Security"
+}
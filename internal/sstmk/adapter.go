@@ -2,36 +2,89 @@ package sstmk
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"log"
+	"strconv"
 	"time"
 
 	"sstmk-onvif/internal/events"
 	"sstmk-onvif/internal/onvif"
+	"sstmk-onvif/internal/snapshot"
 )
 
 type Adapter struct {
 	eventService *onvif.EventService
+	snapshots    *snapshot.Store
 }
 
-func NewAdapter(baseURL string) *Adapter {
+func NewAdapter(baseURL string, evCfg onvif.Config) *Adapter {
 	return &Adapter{
-		eventService: onvif.NewEventService(baseURL),
+		eventService: onvif.NewEventService(baseURL, evCfg),
+		snapshots:    snapshot.NewStore(),
 	}
 }
 
-func (a *Adapter) ProcessEvent(event events.Event) error {
-	if event.Topic != "detector/event" {
-		return nil
-	}
+// detectorPayload — часть events.DetectorPayload, нужная для ONVIF.
+type detectorPayload struct {
+	Image    string               `json:"image"`
+	Detector events.DetectorEvent `json:"detector"`
+}
 
-	var payloadMap map[string]interface{}
-	if err := json.Unmarshal(event.Payload, &payloadMap); err != nil {
-		return err
+// detection переводит событие детектора в данные ONVIF-сообщения.
+func detection(p detectorPayload, account string) onvif.Detection {
+	ev := p.Detector
+	d := onvif.Detection{
+		Image:     p.Image,
+		Account:   account,
+		Type:      ev.Classification.Type,
+		Class:     ev.Classification.Class,
+		Object:    ev.Classification.Object,
+		AlarmsIn:  ev.AlarmsIn,
+		AlarmsOut: ev.AlarmsOut,
+		Zones:     make([][]onvif.ZoneMeasure, len(ev.Zones)),
+	}
+	for i, row := range ev.Zones {
+		d.Zones[i] = make([]onvif.ZoneMeasure, len(row))
+		for j, z := range row {
+			d.Zones[i][j] = onvif.ZoneMeasure{Level: z.Level, Cnt: z.Cnt}
+		}
 	}
+	return d
+}
 
-	image, _ := payloadMap["image"].(string)
-	a.eventService.PublishEvent(event.DeviceID, image, "default")
+func (a *Adapter) ProcessEvent(event events.Event) error {
+	switch event.Topic {
+	case events.TopicDetector:
+		var p detectorPayload
+		if err := json.Unmarshal(event.Payload, &p); err != nil {
+			return err
+		}
+		if img, err := base64.StdEncoding.DecodeString(p.Image); err == nil && len(img) > 0 {
+			a.snapshots.Put(event.DeviceID, img, event.Time)
+		}
+		a.eventService.PublishEvent(event.DeviceID, detection(p, "default"))
+		ev := p.Detector
+		a.eventService.Publish(onvif.NewPassCounterMessage(event.DeviceID, ev.In, ev.Out, ev.Inside))
+
+	case events.TopicInput:
+		var p events.InputPayload
+		if err := json.Unmarshal(event.Payload, &p); err != nil {
+			return err
+		}
+		token := p.Token
+		if token == "" {
+			token = strconv.Itoa(p.Input)
+		}
+		a.eventService.Publish(onvif.NewDigitalInputMessage(event.DeviceID, token, p.State != 0))
+
+	case events.TopicOnline:
+		var p events.OnlinePayload
+		if err := json.Unmarshal(event.Payload, &p); err != nil {
+			return err
+		}
+		a.eventService.Publish(onvif.NewDeviceStatusMessage(event.DeviceID, p.Online))
+	}
 	return nil
 }
 
@@ -60,6 +113,11 @@ func (a *Adapter) Start(ctx context.Context, evbuf events.Buffer) {
 	}()
 }
 
+// GetSnapshots — последние картинки зон по устройствам.
+func (a *Adapter) GetSnapshots() *snapshot.Store {
+	return a.snapshots
+}
+
 func (a *Adapter) GetEventService() *onvif.EventService {
 	return a.eventService
 }
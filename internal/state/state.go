@@ -6,12 +6,21 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"sstmk-onvif/internal/config"
+	"sstmk-onvif/internal/registry"
 )
 
+// saveMu — state.json пишут веб-интерфейс и обработчики реестра из разных
+// горутин; общий временный файл без блокировки перетирается.
+var saveMu sync.Mutex
+
 type State struct {
 	Devices []config.Device `json:"devices"`
+	// UID удалённых через веб-интерфейс устройств: повторная регистрация
+	// по UDP возвращает их выключенными (registry.Register)
+	Deleted []string `json:"deleted,omitempty"`
 }
 
 func LoadOrInit(path string, cfgDevices []config.Device) (*State, error) {
@@ -73,10 +82,27 @@ func LoadOrInit(path string, cfgDevices []config.Device) (*State, error) {
 	}
 }
 
-func SaveDevices(path string, devices []config.Device) error {
-	st := State{
-		Devices: devices,
+// SaveRegistry сохраняет все устройства реестра в state.json: настройки,
+// заданные через ONVIF и веб-интерфейс (scope, EndpointReference и т.п.),
+// переживают перезапуск не только у вшитых устройств. Вшитые сохраняются
+// с online=true.
+func SaveRegistry(path string, reg *registry.Store) error {
+	devices := reg.List()
+	for i := range devices {
+		if registry.IsBuiltIn(devices[i].UID) {
+			devices[i].Online = true
+		}
 	}
+	return save(path, State{Devices: devices, Deleted: reg.Deleted()})
+}
+
+func SaveDevices(path string, devices []config.Device) error {
+	return save(path, State{Devices: devices})
+}
+
+func save(path string, st State) error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
 
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return fmt.Errorf("ошибка создания каталога для state.json: %w", err)
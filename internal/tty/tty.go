@@ -4,16 +4,28 @@ package tty
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"sstmk-onvif/internal/events"
 )
 
+// ErrNotOpen — порт ещё не открыт (или TTY выключен).
+var ErrNotOpen = errors.New("tty: port is not open")
+
+// IO — вход или реле МК, привязанные к устройству.
+type IO struct {
+	Device string // UID устройства
+	Token  string // токен ONVIF
+}
+
 // Пока минимальный конфиг — просто путь до устройства.
 // Потом можно вынести в общий config.Config.
 type Config struct {
@@ -22,24 +34,48 @@ type Config struct {
 	DataBits int    // Биты данных (7, 8)
 	StopBits int    // Стоп-биты (1, 2)
 	Parity   string // Четность ("none", "odd", "even")
+
+	Inputs map[int]IO // номер входа → устройство
+}
+
+// Port — последовательная линия с МК: читает события входов
+// и пишет команды реле.
+type Port struct {
+	cfg Config
+
+	mu sync.Mutex
+	f  *os.File // nil, пока порт не открыт
+}
+
+func New(cfg Config) *Port {
+	return &Port{cfg: cfg}
 }
 
-// Start блокирующе читает строки из tty и пушит их в evbuf.
+// Run блокирующе читает строки из tty и пушит их в evbuf.
 // Формат строки: EVT,<input_id>,<state>\n
-func Start(ctx context.Context, cfg Config, evbuf events.Buffer) error {
-	if cfg.Device == "" {
+func (p *Port) Run(ctx context.Context, evbuf events.Buffer) error {
+	if p.cfg.Device == "" {
 		log.Printf("[tty] disabled (no device)")
 		<-ctx.Done()
 		return ctx.Err()
 	}
 
-	f, err := os.Open(cfg.Device)
+	f, err := os.OpenFile(p.cfg.Device, os.O_RDWR, 0)
 	if err != nil {
-		return fmt.Errorf("[tty] cannot open %s: %w", cfg.Device, err)
+		return fmt.Errorf("[tty] cannot open %s: %w", p.cfg.Device, err)
 	}
 	defer f.Close()
 
-	log.Printf("[tty] listening on %s", cfg.Device)
+	p.mu.Lock()
+	p.f = f
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.f = nil
+		p.mu.Unlock()
+	}()
+
+	log.Printf("[tty] listening on %s", p.cfg.Device)
 
 	// Чтобы ctx отменял блокирующее чтение — закрываем fd,
 	// когда контекст завершится.
@@ -84,17 +120,39 @@ func Start(ctx context.Context, cfg Config, evbuf events.Buffer) error {
 			continue
 		}
 
-		// Минимальный payload — JSON, который дальше можно парсить в UI.
-		payload := []byte(fmt.Sprintf(`{"input":%d,"state":%d}`, inputID, state))
-		log.Printf("%s", payload)
-		ev := events.Event{
-			DeviceID: fmt.Sprintf("tty-input-%d", inputID), // можешь поменять на "gate-001" и т.п.
-			Topic:    "input",
-			Payload:  payload,
-			Time:     time.Now(),
+		// Непривязанный вход виден только в общей ленте событий
+		deviceID := fmt.Sprintf("tty-input-%d", inputID)
+		payload := events.InputPayload{Input: inputID, State: state}
+		if in, ok := p.cfg.Inputs[inputID]; ok {
+			deviceID, payload.Token = in.Device, in.Token
 		}
-		evbuf.Push(ev)
+		b, _ := json.Marshal(payload)
+		log.Printf("%s", b)
+		evbuf.Push(events.Event{
+			DeviceID: deviceID,
+			Topic:    events.TopicInput,
+			Payload:  b,
+			Time:     time.Now(),
+		})
+	}
+}
+
+// SetRelay отправляет МК команду OUT,<relay_id>,<state>\n.
+func (p *Port) SetRelay(id int, active bool) error {
+	state := 0
+	if active {
+		state = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.f == nil {
+		return ErrNotOpen
+	}
+	if _, err := fmt.Fprintf(p.f, "OUT,%d,%d\n", id, state); err != nil {
+		return fmt.Errorf("tty: write relay %d: %w", id, err)
 	}
+	log.Printf("[tty] relay %d -> %d", id, state)
+	return nil
 }
 
 /*
@@ -105,4 +163,5 @@ socat -d -d \
 
 
   echo -e "EVT,3,1" > /tmp/ttyV1
+  cat /tmp/ttyV1   # команды реле: OUT,1,1
 */
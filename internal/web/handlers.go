@@ -7,10 +7,12 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"sstmk-onvif/internal/events"
+	"sstmk-onvif/internal/httpdev"
 	"sstmk-onvif/internal/registry"
 	"sstmk-onvif/internal/state"
 )
@@ -30,10 +32,30 @@ func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	devs := s.reg.List() // берём список из registry
-	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "data": devs})
+	out := make([]deviceView, 0, len(devs))
+	for _, d := range devs {
+		out = append(out, s.view(d))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "data": out})
+}
+
+// deviceView — устройство вместе с состоянием его HTTP-сервера ONVIF.
+type deviceView struct {
+	registry.Device
+	Listener *httpdev.ListenerStatus `json:"listener,omitempty"`
 }
 
-// /api/v1/devices/{id}/(ping|status)
+func (s *Server) view(d registry.Device) deviceView {
+	v := deviceView{Device: d}
+	if s.devServers != nil {
+		if st, ok := s.devServers.Status(d.UID); ok {
+			v.Listener = &st
+		}
+	}
+	return v
+}
+
+// /api/v1/devices/{id}/(ping|status|commands)
 func (s *Server) handleDeviceAPI(w http.ResponseWriter, r *http.Request) {
 	// Expect path like: /api/v1/devices/{id}/ping or /status
 	p := strings.TrimPrefix(r.URL.Path, "/api/v1/devices/")
@@ -48,6 +70,8 @@ func (s *Server) handleDeviceAPI(w http.ResponseWriter, r *http.Request) {
 		s.handleDevicePing(w, r, id)
 	case "status":
 		s.handleDeviceStatus(w, r, id)
+	case "commands":
+		s.handleDeviceCommands(w, r, id)
 	default:
 		http.NotFound(w, r)
 	}
@@ -60,10 +84,20 @@ type devicePatchRequest struct {
 	Vendor       *string `json:"vendor"`
 	SerialNumber *string `json:"serialNumber"`
 	Version      *string `json:"version"`
+	Port         *string `json:"port"`
+
+	Hostname *string   `json:"hostname"`
+	TimeZone *string   `json:"timezone"`
+	DNS      *[]string `json:"dns"`
+	NTP      *[]string `json:"ntp"`
 }
 
 // /api/v1/device/{id}
 func (s *Server) handleDevicePutch(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.handleDeviceDelete(w, r)
+		return
+	}
 	if r.Method != http.MethodPatch {
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{
 			"ok":    false,
@@ -99,7 +133,8 @@ func (s *Server) handleDevicePutch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Enabled == nil && req.Name == nil && req.Vendor == nil && req.SerialNumber == nil && req.Version == nil {
+	if req.Enabled == nil && req.Name == nil && req.Vendor == nil && req.SerialNumber == nil && req.Version == nil && req.Port == nil &&
+		req.Hostname == nil && req.TimeZone == nil && req.DNS == nil && req.NTP == nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{
 			"ok":    false,
 			"error": "at least one field is required",
@@ -107,6 +142,34 @@ func (s *Server) handleDevicePutch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.TimeZone != nil && *req.TimeZone != "" {
+		if _, err := time.LoadLocation(*req.TimeZone); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{
+				"ok":    false,
+				"error": "invalid timezone",
+			})
+			return
+		}
+	}
+
+	if req.Port != nil {
+		if n, err := strconv.Atoi(*req.Port); err != nil || n < 1 || n > 65535 {
+			writeJSON(w, http.StatusBadRequest, map[string]any{
+				"ok":    false,
+				"error": "invalid port",
+			})
+			return
+		}
+		// Сервер устройства переезжает на новый порт (httpdev.Supervisor)
+		if err := s.reg.SetPort(id, *req.Port); err != nil {
+			writeJSON(w, http.StatusConflict, map[string]any{
+				"ok":    false,
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
 	// Получаем текущее устройство
 	dev, ok := s.reg.Get(id)
 	if !ok {
@@ -122,7 +185,7 @@ func (s *Server) handleDevicePutch(w http.ResponseWriter, r *http.Request) {
 		s.reg.SetEnabled(id, *req.Enabled)
 		dev.Enabled = *req.Enabled
 		// Для устройств из конфига (gate-001, gate-002, gate-003, gate-004) online всегда true
-		if strings.HasPrefix(id, "gate-") {
+		if registry.IsBuiltIn(id) {
 			s.reg.SetOnline(id, true)
 			dev.Online = true
 		} else if !*req.Enabled {
@@ -131,7 +194,7 @@ func (s *Server) handleDevicePutch(w http.ResponseWriter, r *http.Request) {
 			dev.Online = false
 		}
 	}
-	if req.Online != nil && !strings.HasPrefix(id, "gate-") {
+	if req.Online != nil && !registry.IsBuiltIn(id) {
 		// Поле online можно редактировать только у не-gate устройств
 		s.reg.SetOnline(id, *req.Online)
 		dev.Online = *req.Online
@@ -148,12 +211,35 @@ func (s *Server) handleDevicePutch(w http.ResponseWriter, r *http.Request) {
 	if req.Version != nil {
 		dev.Version = *req.Version
 	}
+	if req.Hostname != nil {
+		dev.Hostname = *req.Hostname
+	}
+	if req.TimeZone != nil {
+		dev.TimeZone = *req.TimeZone
+	}
+	if req.DNS != nil {
+		dev.DNS = *req.DNS
+	}
+	if req.NTP != nil {
+		dev.NTP = *req.NTP
+	}
 
 	// Обновляем устройство в реестре
 	s.reg.Update(dev)
+	// Update не сбрасывает переопределения (пусто — «оставить как было»),
+	// поэтому они пишутся отдельно: "" и [] возвращают значения шлюза
+	if req.Hostname != nil || req.TimeZone != nil || req.DNS != nil || req.NTP != nil {
+		if err := s.reg.SetOverrides(id, dev.Hostname, dev.TimeZone, dev.DNS, dev.NTP); err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]any{
+				"ok":    false,
+				"error": err.Error(),
+			})
+			return
+		}
+	}
 
-	// Для gate-устройств принудительно устанавливаем online=true в registry
-	if strings.HasPrefix(id, "gate-") {
+	// Для вшитых устройств принудительно устанавливаем online=true в registry
+	if registry.IsBuiltIn(id) {
 		s.reg.SetOnline(id, true)
 	}
 
@@ -167,40 +253,70 @@ func (s *Server) handleDevicePutch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 3) Сохраняем в state.json при любых изменениях
-	devs := s.reg.List()
-	// Фильтруем только встроенные устройства (gate-*) для сохранения в state.json
-	builtInDevs := make([]registry.Device, 0)
-	for _, dev := range devs {
-		if strings.HasPrefix(dev.UID, "gate-") {
-			// Для gate-устройств принудительно устанавливаем online=true перед сохранением
-			dev.Online = true
-			// Также обновляем в registry
-			s.reg.SetOnline(dev.UID, true)
-			builtInDevs = append(builtInDevs, dev)
-		}
-	}
-	if err := state.SaveDevices(s.statePath, builtInDevs); err != nil {
-		log.Printf("state save error: %v", err)
-	}
+	s.saveDevices()
 
 	// 4) Возвращаем обновлённое устройство
 	dev, _ = s.reg.Get(id)
 
 	writeJSON(w, http.StatusOK, map[string]any{
 		"ok":   true,
-		"data": dev,
+		"data": s.view(dev),
 	})
 }
 
+// DELETE /api/v1/device/{id} — устройство пропадает из реестра,
+// его ONVIF-сервисы перестают отвечать.
+func (s *Server) handleDeviceDelete(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/device/")
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"ok":    false,
+			"error": "device id is required",
+		})
+		return
+	}
+	if !s.reg.Delete(id) {
+		writeJSON(w, http.StatusNotFound, map[string]any{
+			"ok":    false,
+			"error": "device not found",
+		})
+		return
+	}
+	s.saveDevices()
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// saveDevices сохраняет устройства реестра в state.json.
+func (s *Server) saveDevices() {
+	for _, dev := range s.reg.List() {
+		if registry.IsBuiltIn(dev.UID) {
+			// Для вшитых устройств принудительно устанавливаем online=true в registry
+			s.reg.SetOnline(dev.UID, true)
+		}
+	}
+	if err := state.SaveRegistry(s.statePath, s.reg); err != nil {
+		log.Printf("state save error: %v", err)
+	}
+}
+
 func (s *Server) handleDevicePing(w http.ResponseWriter, r *http.Request, id string) {
 	if r.Method != http.MethodPost {
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"ok": false, "error": "method not allowed"})
 		return
 	}
-	// Drain body (ack/metrics not used yet)
+	// Тело: {"ack": ["<id команды>", ...]} — подтверждения выполненных команд
+	var body struct {
+		Ack []string `json:"ack"`
+	}
 	var buf bytes.Buffer
 	_, _ = io.Copy(&buf, r.Body)
 	_ = r.Body.Close()
+	if buf.Len() > 0 {
+		_ = json.Unmarshal(buf.Bytes(), &body)
+	}
+	for _, ack := range body.Ack {
+		s.commands.Complete(ack, nil)
+	}
 
 	// mark device online
 	s.reg.SetOnline(id, true)
@@ -217,6 +333,15 @@ func (s *Server) handleDevicePing(w http.ResponseWriter, r *http.Request, id str
 	})
 }
 
+// GET /api/v1/devices/{id}/commands — команды устройству и их состояние
+func (s *Server) handleDeviceCommands(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"ok": false, "error": "method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.commands.List(id))
+}
+
 func (s *Server) handleDeviceStatus(w http.ResponseWriter, r *http.Request, id string) {
 	if r.Method != http.MethodPost {
 		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"ok": false, "error": "method not allowed"})
@@ -0,0 +1,70 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sstmk-onvif/internal/registry"
+)
+
+func patchDevice(t *testing.T, s *Server, id, body string) registry.Device {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.handleDevicePutch(w, httptest.NewRequest(http.MethodPatch, "/api/v1/device/"+id, strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH %s: %d %s", body, w.Code, w.Body)
+	}
+	var resp struct {
+		Data registry.Device `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Data
+}
+
+// Переопределение, заданное через PATCH, сбрасывается пустым значением —
+// устройство снова отдаёт значения шлюза.
+func TestPatchClearsOverrides(t *testing.T) {
+	reg := registry.NewStore()
+	reg.Upsert(registry.Device{UID: "det-1", Enabled: true})
+	s := &Server{reg: reg, statePath: filepath.Join(t.TempDir(), "state.json")}
+
+	got := patchDevice(t, s, "det-1", `{"hostname":"gate-a","timezone":"Europe/Moscow","dns":["10.0.0.1"],"ntp":["pool.ntp.org"]}`)
+	if got.Hostname != "gate-a" || got.TimeZone != "Europe/Moscow" || len(got.DNS) != 1 || len(got.NTP) != 1 {
+		t.Fatalf("after set: %+v", got)
+	}
+
+	// повторная регистрация адаптером переопределения не трогает
+	reg.Upsert(registry.Device{UID: "det-1", Enabled: true, Name: "renamed"})
+	if d, _ := reg.Get("det-1"); d.Hostname != "gate-a" || len(d.DNS) != 1 {
+		t.Fatalf("after re-registration: %+v", d)
+	}
+
+	patchDevice(t, s, "det-1", `{"hostname":"","timezone":"","dns":[],"ntp":[]}`)
+	d, _ := reg.Get("det-1")
+	if d.Hostname != "" || d.TimeZone != "" || d.DNS != nil || d.NTP != nil {
+		t.Errorf("after clear: hostname=%q timezone=%q dns=%v ntp=%v", d.Hostname, d.TimeZone, d.DNS, d.NTP)
+	}
+	if d.Name != "renamed" {
+		t.Errorf("unrelated field changed: name=%q", d.Name)
+	}
+}
+
+// Поля, которых нет в PATCH, остаются как были.
+func TestPatchKeepsOmittedOverrides(t *testing.T) {
+	reg := registry.NewStore()
+	reg.Upsert(registry.Device{UID: "det-1", Enabled: true})
+	s := &Server{reg: reg, statePath: filepath.Join(t.TempDir(), "state.json")}
+
+	patchDevice(t, s, "det-1", `{"hostname":"gate-a","ntp":["pool.ntp.org"]}`)
+	patchDevice(t, s, "det-1", `{"ntp":null,"name":"x"}`)
+	d, _ := reg.Get("det-1")
+	if d.Hostname != "gate-a" || len(d.NTP) != 1 {
+		t.Errorf("hostname=%q ntp=%v", d.Hostname, d.NTP)
+	}
+}
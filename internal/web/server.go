@@ -12,8 +12,11 @@ import (
 	"time"
 
 	"sstmk-onvif/internal/config"
+	"sstmk-onvif/internal/devcmd"
+	"sstmk-onvif/internal/discovery"
 	"sstmk-onvif/internal/events"
 
+	"sstmk-onvif/internal/httpdev"
 	"sstmk-onvif/internal/hub"
 	"sstmk-onvif/internal/registry"
 )
@@ -26,9 +29,12 @@ type Server struct {
 	hub          *hub.Hub
 	statePath    string       // хранение данных
 	eventService http.Handler // ONVIF Event Service
+	devServers   *httpdev.Supervisor
+	commands     *devcmd.Dispatcher // команды устройствам (reboot, factory_reset)
+	discovery    *discovery.Service // направленный WS-Discovery по HTTP
 }
 
-func New(cfg config.WebConfig, reg *registry.Store, evbuf events.Buffer, hub *hub.Hub, statePath string, eventService http.Handler) *Server {
+func New(cfg config.WebConfig, reg *registry.Store, evbuf events.Buffer, hub *hub.Hub, statePath string, eventService http.Handler, devServers *httpdev.Supervisor, commands *devcmd.Dispatcher, disc *discovery.Service) *Server {
 	mux := http.NewServeMux()
 
 	// --- SSE событий из ring buffer ---
@@ -40,6 +46,9 @@ func New(cfg config.WebConfig, reg *registry.Store, evbuf events.Buffer, hub *hu
 		hub:          hub,
 		statePath:    statePath,
 		eventService: eventService,
+		devServers:   devServers,
+		commands:     commands,
+		discovery:    disc,
 	}
 
 	mux.HandleFunc("/api/v1/health", s.handleHealth)
@@ -52,6 +61,9 @@ func New(cfg config.WebConfig, reg *registry.Store, evbuf events.Buffer, hub *hu
 	mux.Handle("/onvif/events", s.eventService)
 	mux.Handle("/onvif/events/", http.StripPrefix("/onvif/events", s.eventService))
 
+	// WS-Discovery: направленные Probe/Resolve для VMS из других подсетей
+	mux.Handle("/onvif/discovery", s.discovery)
+
 	// --- STATIC ---
 	staticDir := filepath.Clean(cfg.StaticDir)
 	useStatic := staticDir != "" && dirExists(staticDir) && fileExists(filepath.Join(staticDir, "index.html"))